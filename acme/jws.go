@@ -0,0 +1,205 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+)
+
+// JWK is a (rfc7517) json web key, only the members that are
+// needed to create the public key and thumbprint are parsed.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey will create the public key of the json web key
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", j.Kty)
+	}
+}
+
+// Thumbprint returns the (rfc7638) base64url encoded sha256 thumbprint
+func (j JWK) Thumbprint() string {
+	var members string
+	switch j.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, j.E, j.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, j.Crv, j.X, j.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, j.Crv, j.Kty, j.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// the flattened json serialization (rfc7515 7.2.2) as used by acme
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string `json:"alg"`
+	Nonce string `json:"nonce"`
+	Url   string `json:"url"`
+	Kid   string `json:"kid"`
+	Jwk   *JWK   `json:"jwk"`
+}
+
+// parseJWS will decode the request body and verify the signature with the
+// embedded key or the key returned by the lookup for the key id.
+func parseJWS(body []byte, lookup func(kid string) (*JWK, error)) (*jwsHeader, []byte, error) {
+	var object jws
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, nil, malformed("invalid jws: %s", err)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(object.Protected)
+	if err != nil {
+		return nil, nil, malformed("invalid protected header")
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, nil, malformed("invalid protected header: %s", err)
+	}
+	if (header.Jwk == nil) == (header.Kid == "") {
+		return nil, nil, malformed("the protected header should contain a jwk or kid")
+	}
+	key := header.Jwk
+	if key == nil {
+		if key, err = lookup(header.Kid); err != nil {
+			return nil, nil, err
+		}
+	}
+	public, err := key.PublicKey()
+	if err != nil {
+		return nil, nil, NewProblem(ERROR_BAD_PUBLIC_KEY, http.StatusBadRequest, "%s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(object.Signature)
+	if err != nil {
+		return nil, nil, malformed("invalid signature encoding")
+	}
+	if err := verifySignature(header.Alg, public, []byte(object.Protected+"."+object.Payload), signature); err != nil {
+		return nil, nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(object.Payload)
+	if err != nil {
+		return nil, nil, malformed("invalid payload encoding")
+	}
+	if header.Jwk == nil {
+		header.Jwk = key
+	}
+	return &header, payload, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, data, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return NewProblem(ERROR_BAD_SIGNATURE_ALG, http.StatusBadRequest, "unsupported signature algorithm '%s'", alg)
+	}
+	var valid bool
+	switch t := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] == "RS" {
+			h := hash.New()
+			h.Write(data)
+			valid = rsa.VerifyPKCS1v15(t, hash, h.Sum(nil), signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		// the curve should match the algorithm so ES256 is only used with P-256 etc.
+		bits := t.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if alg == fmt.Sprintf("ES%d", map[int]int{256: 256, 384: 384, 521: 512}[bits]) && len(signature) == 2*size {
+			h := hash.New()
+			h.Write(data)
+			r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(t, h.Sum(nil), r, s)
+		}
+	case ed25519.PublicKey:
+		if alg == "EdDSA" {
+			valid = ed25519.Verify(t, data, signature)
+		}
+	}
+	if !valid {
+		return malformed("invalid jws signature")
+	}
+	return nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
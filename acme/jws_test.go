@@ -0,0 +1,46 @@
+package acme
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// the example of rfc7638 section 3.1
+	key := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	if thumbprint := key.Thumbprint(); thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint %s", thumbprint)
+	}
+}
+
+func TestParseJWS(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	header, _ := json.Marshal(map[string]interface{}{
+		"alg":   "EdDSA",
+		"nonce": "nonce",
+		"url":   "http://localhost/acme/new-account",
+		"jwk":   JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public)},
+	})
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"termsOfServiceAgreed":true}`))
+	signature := base64.RawURLEncoding.EncodeToString(ed25519.Sign(private, []byte(protected+"."+payload)))
+	body, _ := json.Marshal(jws{Protected: protected, Payload: payload, Signature: signature})
+	parsed, data, err := parseJWS(body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Url != "http://localhost/acme/new-account" || string(data) != `{"termsOfServiceAgreed":true}` {
+		t.Fatal("unexpected header or payload")
+	}
+	body, _ = json.Marshal(jws{Protected: protected, Payload: base64.RawURLEncoding.EncodeToString([]byte("{}")), Signature: signature})
+	if _, _, err := parseJWS(body, nil); err == nil {
+		t.Fatal("expected an error for a modified payload")
+	}
+}
@@ -0,0 +1,58 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const (
+	// the time a nonce can be used
+	nonceLifetime = time.Hour
+	// the max number of nonces that are kept, every response has a new nonce
+	// so when more are issued the oldest will be dropped (and a client will
+	// get a badNonce error and retry with the nonce of that response).
+	nonceLimit = 10000
+)
+
+// nonces keeps track of the issued (rfc8555 6.5) replay nonces, a
+// nonce can only be used once and will expire after nonceLifetime.
+type nonces struct {
+	issued map[string]time.Time
+	// the issued nonces in order of issuing, used as a ring buffer
+	// so the oldest nonce is replaced when the limit is reached.
+	order []string
+	next  int
+	lock  sync.Mutex
+}
+
+func (n *nonces) New() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.issued == nil {
+		n.issued = make(map[string]time.Time)
+	}
+	if len(n.order) < nonceLimit {
+		n.order = append(n.order, nonce)
+	} else {
+		// a nonce that is used is already removed
+		delete(n.issued, n.order[n.next])
+		n.order[n.next] = nonce
+		n.next = (n.next + 1) % nonceLimit
+	}
+	n.issued[nonce] = time.Now().Add(nonceLifetime)
+	return nonce
+}
+
+// Use will return true when the nonce was issued and is not used or expired
+func (n *nonces) Use(nonce string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	expires, ok := n.issued[nonce]
+	delete(n.issued, nonce)
+	return ok && time.Now().Before(expires)
+}
@@ -0,0 +1,28 @@
+package acme
+
+import "testing"
+
+func TestNonces_Limit(t *testing.T) {
+	n := new(nonces)
+	first, second := n.New(), n.New()
+
+	if !n.Use(second) || n.Use(second) {
+		t.Fatal("expected the nonce to be used only once")
+	}
+
+	for i := 0; i < nonceLimit; i++ {
+		n.New()
+	}
+
+	if len(n.issued) > nonceLimit || len(n.order) != nonceLimit {
+		t.Fatalf("expected at most %d nonces got %d", nonceLimit, len(n.issued))
+	}
+
+	if n.Use(first) {
+		t.Fatal("expected the oldest nonce to be dropped")
+	}
+
+	if last := n.New(); !n.Use(last) {
+		t.Fatal("expected the last nonce to be valid")
+	}
+}
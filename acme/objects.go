@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"time"
+
+	"github.com/pbergman/caserver/ca"
+)
+
+// the (rfc8555 7.1.6) status values of the objects
+const (
+	STATUS_PENDING     = "pending"
+	STATUS_READY       = "ready"
+	STATUS_PROCESSING  = "processing"
+	STATUS_VALID       = "valid"
+	STATUS_INVALID     = "invalid"
+	STATUS_DEACTIVATED = "deactivated"
+	STATUS_EXPIRED     = "expired"
+)
+
+// the supported identifier and challenge types
+const (
+	IDENTIFIER_DNS = "dns"
+	IDENTIFIER_IP  = "ip"
+
+	CHALLENGE_HTTP_01 = "http-01"
+	CHALLENGE_DNS_01  = "dns-01"
+)
+
+// the time before an order or authorization expires
+const objectLifetime = 7 * 24 * time.Hour
+
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Account is the (rfc8555 7.1.2) account of a client, the id is
+// based on the thumbprint of the key so a key has one account.
+type Account struct {
+	Id                   string    `json:"id"`
+	Key                  *JWK      `json:"key"`
+	Status               string    `json:"status"`
+	Contact              []string  `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool      `json:"terms_of_service_agreed"`
+	Orders               []string  `json:"orders,omitempty"`
+	Created              time.Time `json:"created"`
+}
+
+// Order is the (rfc8555 7.1.3) request for a certificate, the
+// certificate is the storage key of the issued certificate.
+type Order struct {
+	Id             string       `json:"id"`
+	Account        string       `json:"account"`
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	NotBefore      *time.Time   `json:"not_before,omitempty"`
+	NotAfter       *time.Time   `json:"not_after,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+// getValidity returns the requested validity or nil when not requested
+func (o *Order) getValidity() *ca.Validity {
+	if o.NotBefore == nil && o.NotAfter == nil {
+		return nil
+	}
+	validity := new(ca.Validity)
+	if o.NotBefore != nil {
+		validity.NotBefore = *o.NotBefore
+	}
+	if o.NotAfter != nil {
+		validity.NotAfter = *o.NotAfter
+	}
+	return validity
+}
+
+// Authorization is the (rfc8555 7.1.4) proof that an account
+// controls the identifier, which is done with one of the challenges.
+type Authorization struct {
+	Id         string       `json:"id"`
+	Account    string       `json:"account"`
+	Status     string       `json:"status"`
+	Expires    time.Time    `json:"expires"`
+	Identifier Identifier   `json:"identifier"`
+	Wildcard   bool         `json:"wildcard,omitempty"`
+	Challenges []*Challenge `json:"challenges"`
+}
+
+// GetChallenge returns the challenge of the given type or nil when not found
+func (a *Authorization) GetChallenge(name string) *Challenge {
+	for _, challenge := range a.Challenges {
+		if challenge.Type == name {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// Challenge is a (rfc8555 8) challenge of an authorization
+type Challenge struct {
+	Type      string     `json:"type"`
+	Token     string     `json:"token"`
+	Status    string     `json:"status"`
+	Validated *time.Time `json:"validated,omitempty"`
+	Error     *Problem   `json:"error,omitempty"`
+}
+
+// the json representations of the objects as returned to the clients
+
+type accountView struct {
+	Status               string   `json:"status"`
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	Orders               string   `json:"orders"`
+}
+
+type orderView struct {
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	NotBefore      string       `json:"notBefore,omitempty"`
+	NotAfter       string       `json:"notAfter,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+}
+
+type authorizationView struct {
+	Identifier Identifier      `json:"identifier"`
+	Status     string          `json:"status"`
+	Expires    string          `json:"expires"`
+	Challenges []challengeView `json:"challenges"`
+	Wildcard   bool            `json:"wildcard,omitempty"`
+}
+
+type challengeView struct {
+	Type      string   `json:"type"`
+	Url       string   `json:"url"`
+	Status    string   `json:"status"`
+	Token     string   `json:"token"`
+	Validated string   `json:"validated,omitempty"`
+	Error     *Problem `json:"error,omitempty"`
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
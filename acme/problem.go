@@ -0,0 +1,71 @@
+package acme
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// the (rfc8555 6.7) error types that are used by the server
+const (
+	ERROR_ACCOUNT_DOES_NOT_EXIST = "accountDoesNotExist"
+	ERROR_BAD_CSR                = "badCSR"
+	ERROR_BAD_NONCE              = "badNonce"
+	ERROR_BAD_PUBLIC_KEY         = "badPublicKey"
+	ERROR_BAD_SIGNATURE_ALG      = "badSignatureAlgorithm"
+	ERROR_CONNECTION             = "connection"
+	ERROR_DNS                    = "dns"
+	ERROR_INCORRECT_RESPONSE     = "incorrectResponse"
+	ERROR_MALFORMED              = "malformed"
+	ERROR_ORDER_NOT_READY        = "orderNotReady"
+	ERROR_REJECTED_IDENTIFIER    = "rejectedIdentifier"
+	ERROR_SERVER_INTERNAL        = "serverInternal"
+	ERROR_UNAUTHORIZED           = "unauthorized"
+	ERROR_UNSUPPORTED_CONTACT    = "unsupportedContact"
+	ERROR_UNSUPPORTED_IDENTIFIER = "unsupportedIdentifier"
+)
+
+// Problem is a (rfc7807) problem document as returned by the server
+// and used for failed challenges, authorizations and orders.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return p.Type + ": " + p.Detail
+}
+
+// NewProblem creates a problem of the given (ERROR_*) type
+func NewProblem(name string, status int, format string, args ...interface{}) *Problem {
+	return &Problem{
+		Type:   "urn:ietf:params:acme:error:" + name,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func malformed(format string, args ...interface{}) *Problem {
+	return NewProblem(ERROR_MALFORMED, http.StatusBadRequest, format, args...)
+}
+
+func notFound(kind string) *Problem {
+	return NewProblem(ERROR_MALFORMED, http.StatusNotFound, "%s not found", kind)
+}
+
+func unauthorized(format string, args ...interface{}) *Problem {
+	return NewProblem(ERROR_UNAUTHORIZED, http.StatusForbidden, format, args...)
+}
+
+func serverInternal(err error) *Problem {
+	return NewProblem(ERROR_SERVER_INTERNAL, http.StatusInternalServerError, "%s", err)
+}
+
+// ToProblem will return the error as problem, errors that are not
+// a problem are returned as an internal server error.
+func ToProblem(err error) *Problem {
+	if problem, ok := err.(*Problem); ok {
+		return problem
+	}
+	return serverInternal(err)
+}
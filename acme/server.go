@@ -0,0 +1,742 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/storage"
+)
+
+// a (lowercase) domain name with an optional wildcard label
+var validDomain = regexp.MustCompile(`^(?:\*\.)?(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Server is the (rfc8555) ACME server of a CA, the certificates are
+// issued with the manager of the CA and the accounts, orders and
+// authorizations are stored as json files in the given path.
+type Server struct {
+	manager *ca.Manager
+	conf    *config.AcmeConfig
+	base    string
+	store   *store
+	nonces  nonces
+	// checks the challenge, can be replaced for testing
+	validate func(*Challenge, Identifier, string) *Problem
+	lock     sync.Mutex
+}
+
+func NewServer(manager *ca.Manager, conf *config.Config, path string) *Server {
+	base := conf.GetUrl() + "/acme"
+	if manager.Name() != config.DEFAULT_CA {
+		base += "/" + manager.Name()
+	}
+	return &Server{
+		manager:  manager,
+		conf:     &conf.Acme,
+		base:     base,
+		store:    newStore(path),
+		validate: newValidator(&conf.Acme).Validate,
+	}
+}
+
+// Request is a verified request of a client, the account
+// will be nil when the request was signed with a jwk.
+type Request struct {
+	Account *Account
+	Key     *JWK
+	Payload []byte
+}
+
+// IsPostAsGet checks if the request has an empty payload (rfc8555 6.3)
+func (r Request) IsPostAsGet() bool {
+	return len(r.Payload) == 0
+}
+
+// Url returns the public url for the given path relative to the acme endpoint
+func (s *Server) Url(path string) string {
+	return s.base + path
+}
+
+func (s *Server) AccountUrl(id string) string {
+	return s.Url("/account/" + id)
+}
+
+func (s *Server) OrderUrl(id string) string {
+	return s.Url("/order/" + id)
+}
+
+func (s *Server) AuthorizationUrl(id string) string {
+	return s.Url("/authz/" + id)
+}
+
+func (s *Server) NewNonce() string {
+	return s.nonces.New()
+}
+
+// Directory returns the (rfc8555 7.1.1) directory object
+func (s *Server) Directory() map[string]interface{} {
+	return map[string]interface{}{
+		"newNonce":   s.Url("/new-nonce"),
+		"newAccount": s.Url("/new-account"),
+		"newOrder":   s.Url("/new-order"),
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	}
+}
+
+// Verify will check the signature, nonce and url of the (jws) request body, the
+// path should be the path of the request relative to the acme endpoint.
+func (s *Server) Verify(body []byte, path string) (*Request, error) {
+	var account *Account
+	header, payload, err := parseJWS(body, func(kid string) (*JWK, error) {
+		if !strings.HasPrefix(kid, s.AccountUrl("")) {
+			return nil, NewProblem(ERROR_ACCOUNT_DOES_NOT_EXIST, http.StatusBadRequest, "unknown account '%s'", kid)
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if found, err := s.getAccount(strings.TrimPrefix(kid, s.AccountUrl(""))); err != nil {
+			return nil, err
+		} else {
+			account = found
+		}
+		if account.Status != STATUS_VALID {
+			return nil, unauthorized("the account is %s", account.Status)
+		}
+		return account.Key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !s.nonces.Use(header.Nonce) {
+		return nil, NewProblem(ERROR_BAD_NONCE, http.StatusBadRequest, "invalid or expired nonce")
+	}
+	if header.Url != s.Url(path) {
+		return nil, unauthorized("the url '%s' does not match the request url", header.Url)
+	}
+	return &Request{Account: account, Key: header.Jwk, Payload: payload}, nil
+}
+
+// NewAccount will create the account for the key of the request or return the
+// existing account, the returned bool will be true when the account was created.
+func (s *Server) NewAccount(req *Request) (*Account, bool, error) {
+	if req.Account != nil {
+		return nil, false, malformed("a new account request should contain a jwk")
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, false, malformed("invalid payload: %s", err)
+	}
+	sum := sha256.Sum256([]byte(req.Key.Thumbprint()))
+	id := hex.EncodeToString(sum[:16])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if account, err := s.getAccount(id); err == nil {
+		if account.Status != STATUS_VALID {
+			return nil, false, unauthorized("the account is %s", account.Status)
+		}
+		return account, false, nil
+	} else if problem, ok := err.(*Problem); !ok || !strings.HasSuffix(problem.Type, ERROR_ACCOUNT_DOES_NOT_EXIST) {
+		return nil, false, err
+	}
+	if payload.OnlyReturnExisting {
+		return nil, false, NewProblem(ERROR_ACCOUNT_DOES_NOT_EXIST, http.StatusBadRequest, "no account exists for the key")
+	}
+	if err := checkContact(payload.Contact); err != nil {
+		return nil, false, err
+	}
+	account := &Account{
+		Id:                   id,
+		Key:                  req.Key,
+		Status:               STATUS_VALID,
+		Contact:              payload.Contact,
+		TermsOfServiceAgreed: payload.TermsOfServiceAgreed,
+		Created:              time.Now().UTC(),
+	}
+	if err := s.store.save("account", account.Id, account); err != nil {
+		return nil, false, serverInternal(err)
+	}
+	return account, true, nil
+}
+
+// UpdateAccount will update the contacts or deactivate the account (rfc8555 7.3.2
+// and 7.3.6), a request without payload will only return the account.
+func (s *Server) UpdateAccount(req *Request, id string) (*Account, error) {
+	if err := s.checkAccount(req, id); err != nil {
+		return nil, err
+	}
+	if req.IsPostAsGet() {
+		return req.Account, nil
+	}
+	var payload struct {
+		Contact []string `json:"contact"`
+		Status  string   `json:"status"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, malformed("invalid payload: %s", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account, err := s.getAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	switch payload.Status {
+	case "":
+	case STATUS_DEACTIVATED:
+		account.Status = STATUS_DEACTIVATED
+	default:
+		return nil, malformed("invalid status '%s'", payload.Status)
+	}
+	if payload.Contact != nil {
+		if err := checkContact(payload.Contact); err != nil {
+			return nil, err
+		}
+		account.Contact = payload.Contact
+	}
+	if err := s.store.save("account", account.Id, account); err != nil {
+		return nil, serverInternal(err)
+	}
+	return account, nil
+}
+
+// AccountOrders returns the urls of the orders of the account
+func (s *Server) AccountOrders(req *Request, id string) ([]string, error) {
+	if err := s.checkAccount(req, id); err != nil {
+		return nil, err
+	}
+	list := make([]string, 0)
+	for _, order := range req.Account.Orders {
+		list = append(list, s.OrderUrl(order))
+	}
+	return list, nil
+}
+
+// NewOrder will check the identifiers of the requested order and create
+// the authorizations for the identifiers (rfc8555 7.4).
+func (s *Server) NewOrder(req *Request) (*Order, error) {
+	if req.Account == nil {
+		return nil, malformed("the request should be signed with an account (kid)")
+	}
+	var payload struct {
+		Identifiers []Identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, malformed("invalid payload: %s", err)
+	}
+	identifiers, err := s.checkIdentifiers(payload.Identifiers)
+	if err != nil {
+		return nil, err
+	}
+	order := &Order{
+		Id:          newId(),
+		Account:     req.Account.Id,
+		Status:      STATUS_PENDING,
+		Expires:     time.Now().Add(objectLifetime).UTC(),
+		Identifiers: identifiers,
+	}
+	for dst, value := range map[**time.Time]string{&order.NotBefore: payload.NotBefore, &order.NotAfter: payload.NotAfter} {
+		if value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, malformed("invalid time '%s', expected a RFC3339 formatted time", value)
+			}
+			*dst = &t
+		}
+	}
+	if validity := order.getValidity(); validity != nil {
+		if err := validity.Validate(); err != nil {
+			return nil, malformed("%s", err)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, identifier := range identifiers {
+		authz := s.newAuthorization(req.Account.Id, identifier)
+		if err := s.store.save("authz", authz.Id, authz); err != nil {
+			return nil, serverInternal(err)
+		}
+		order.Authorizations = append(order.Authorizations, authz.Id)
+	}
+	if err := s.updateOrder(order); err != nil {
+		return nil, err
+	}
+	account, err := s.getAccount(req.Account.Id)
+	if err != nil {
+		return nil, err
+	}
+	account.Orders = append(account.Orders, order.Id)
+	if err := s.store.save("account", account.Id, account); err != nil {
+		return nil, serverInternal(err)
+	}
+	return order, nil
+}
+
+// GetOrder returns the order with an updated status
+func (s *Server) GetOrder(req *Request, id string) (*Order, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.getOrder(req, id)
+}
+
+// Finalize will issue the certificate for the csr of the request when
+// all authorizations of the order are valid (rfc8555 7.4).
+func (s *Server) Finalize(req *Request, id string) (*Order, error) {
+	var payload struct {
+		Csr string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, malformed("invalid payload: %s", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	order, err := s.getOrder(req, id)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != STATUS_READY {
+		return nil, NewProblem(ERROR_ORDER_NOT_READY, http.StatusForbidden, "the order is %s", order.Status)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload.Csr)
+	if err != nil {
+		return nil, NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "invalid csr encoding")
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "%s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "%s", err)
+	}
+	if err := checkCsr(csr, order.Identifiers); err != nil {
+		return nil, err
+	}
+	key, err := s.issue(csr, order)
+	if err != nil {
+		return nil, err
+	}
+	order.Status = STATUS_VALID
+	order.Certificate = key.String()
+	if err := s.store.save("order", order.Id, order); err != nil {
+		return nil, serverInternal(err)
+	}
+	return order, nil
+}
+
+// GetCertificate returns the certificate record of a valid order
+func (s *Server) GetCertificate(req *Request, id string) (storage.Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	order, err := s.getOrder(req, id)
+	if err != nil {
+		return nil, err
+	}
+	if order.Certificate == "" {
+		return nil, notFound("certificate")
+	}
+	record := s.manager.Get(storage.NewStorageKeyFromString(order.Certificate))
+	if record == nil || !record.HasCertificate() {
+		return nil, notFound("certificate")
+	}
+	return record, nil
+}
+
+// WriteCertificate will write the pem encoded certificate followed
+// by the certificates of the chain (rfc8555 7.4.2).
+func (s *Server) WriteCertificate(w io.Writer, record storage.Record) error {
+	for _, r := range append([]storage.Record{record}, s.manager.GetChain(record)...) {
+		if err := r.WriteCertificate(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAuthorization will return or deactivate (rfc8555 7.5.2) the authorization
+func (s *Server) GetAuthorization(req *Request, id string) (*Authorization, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	authz, err := s.getAuthorization(req, id)
+	if err != nil || req.IsPostAsGet() {
+		return authz, err
+	}
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, malformed("invalid payload: %s", err)
+	}
+	if payload.Status != STATUS_DEACTIVATED {
+		return nil, malformed("invalid status '%s'", payload.Status)
+	}
+	if authz.Status == STATUS_PENDING || authz.Status == STATUS_VALID {
+		authz.Status = STATUS_DEACTIVATED
+		if err := s.store.save("authz", authz.Id, authz); err != nil {
+			return nil, serverInternal(err)
+		}
+	}
+	return authz, nil
+}
+
+// RespondChallenge will validate the challenge when the request has
+// a payload (rfc8555 7.5.1) and return the challenge and authorization.
+func (s *Server) RespondChallenge(req *Request, id, name string) (*Challenge, *Authorization, error) {
+	s.lock.Lock()
+	authz, err := s.getAuthorization(req, id)
+	if err != nil {
+		s.lock.Unlock()
+		return nil, nil, err
+	}
+	challenge := authz.GetChallenge(name)
+	if challenge == nil {
+		s.lock.Unlock()
+		return nil, nil, notFound("challenge")
+	}
+	if req.IsPostAsGet() || authz.Status != STATUS_PENDING || challenge.Status != STATUS_PENDING {
+		s.lock.Unlock()
+		return challenge, authz, nil
+	}
+	challenge.Status = STATUS_PROCESSING
+	if err := s.store.save("authz", authz.Id, authz); err != nil {
+		s.lock.Unlock()
+		return nil, nil, serverInternal(err)
+	}
+	s.lock.Unlock()
+	// the lock is released so other requests are not blocked by the validation
+	problem := s.validate(challenge, authz.Identifier, challenge.Token+"."+req.Account.Key.Thumbprint())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if authz, err = s.getAuthorization(req, id); err != nil {
+		return nil, nil, err
+	}
+	challenge = authz.GetChallenge(name)
+	if problem == nil {
+		now := time.Now().UTC()
+		challenge.Status, challenge.Validated = STATUS_VALID, &now
+		authz.Status = STATUS_VALID
+	} else {
+		challenge.Status, challenge.Error = STATUS_INVALID, problem
+		authz.Status = STATUS_INVALID
+	}
+	if err := s.store.save("authz", authz.Id, authz); err != nil {
+		return nil, nil, serverInternal(err)
+	}
+	return challenge, authz, nil
+}
+
+// AccountView returns the json representation of the account
+func (s *Server) AccountView(account *Account) interface{} {
+	return accountView{
+		Status:               account.Status,
+		Contact:              account.Contact,
+		TermsOfServiceAgreed: account.TermsOfServiceAgreed,
+		Orders:               s.AccountUrl(account.Id) + "/orders",
+	}
+}
+
+// OrderView returns the json representation of the order
+func (s *Server) OrderView(order *Order) interface{} {
+	view := orderView{
+		Status:         order.Status,
+		Expires:        formatTime(&order.Expires),
+		Identifiers:    order.Identifiers,
+		NotBefore:      formatTime(order.NotBefore),
+		NotAfter:       formatTime(order.NotAfter),
+		Error:          order.Error,
+		Authorizations: make([]string, 0),
+		Finalize:       s.OrderUrl(order.Id) + "/finalize",
+	}
+	for _, id := range order.Authorizations {
+		view.Authorizations = append(view.Authorizations, s.AuthorizationUrl(id))
+	}
+	if order.Certificate != "" {
+		view.Certificate = s.Url("/cert/" + order.Id)
+	}
+	return view
+}
+
+// AuthorizationView returns the json representation of the authorization
+func (s *Server) AuthorizationView(authz *Authorization) interface{} {
+	view := authorizationView{
+		Identifier: authz.Identifier,
+		Status:     authz.Status,
+		Expires:    formatTime(&authz.Expires),
+		Challenges: make([]challengeView, 0),
+		Wildcard:   authz.Wildcard,
+	}
+	for _, challenge := range authz.Challenges {
+		view.Challenges = append(view.Challenges, s.ChallengeView(authz, challenge).(challengeView))
+	}
+	return view
+}
+
+// ChallengeView returns the json representation of the challenge
+func (s *Server) ChallengeView(authz *Authorization, challenge *Challenge) interface{} {
+	return challengeView{
+		Type:      challenge.Type,
+		Url:       s.AuthorizationUrl(authz.Id) + "/" + challenge.Type,
+		Status:    challenge.Status,
+		Token:     challenge.Token,
+		Validated: formatTime(challenge.Validated),
+		Error:     challenge.Error,
+	}
+}
+
+func (s *Server) getAccount(id string) (*Account, error) {
+	account := new(Account)
+	if found, err := s.store.load("account", id, account); err != nil {
+		return nil, serverInternal(err)
+	} else if !found {
+		return nil, NewProblem(ERROR_ACCOUNT_DOES_NOT_EXIST, http.StatusBadRequest, "unknown account '%s'", id)
+	}
+	return account, nil
+}
+
+// checkAccount checks if the request is signed by the account with the given id
+func (s *Server) checkAccount(req *Request, id string) error {
+	if req.Account == nil {
+		return malformed("the request should be signed with an account (kid)")
+	}
+	if req.Account.Id != id {
+		return unauthorized("the account does not match the account of the request")
+	}
+	return nil
+}
+
+func (s *Server) getOrder(req *Request, id string) (*Order, error) {
+	order := new(Order)
+	if found, err := s.store.load("order", id, order); err != nil {
+		return nil, serverInternal(err)
+	} else if !found {
+		return nil, notFound("order")
+	}
+	if err := s.checkAccount(req, order.Account); err != nil {
+		return nil, err
+	}
+	return order, s.updateOrder(order)
+}
+
+func (s *Server) getAuthorization(req *Request, id string) (*Authorization, error) {
+	authz := new(Authorization)
+	if found, err := s.store.load("authz", id, authz); err != nil {
+		return nil, serverInternal(err)
+	} else if !found {
+		return nil, notFound("authorization")
+	}
+	if err := s.checkAccount(req, authz.Account); err != nil {
+		return nil, err
+	}
+	if authz.Status == STATUS_PENDING && time.Now().After(authz.Expires) {
+		authz.Status = STATUS_EXPIRED
+	}
+	return authz, nil
+}
+
+// updateOrder will update the status of a pending order based on the status
+// of its authorizations (rfc8555 7.1.6) and persist the order.
+func (s *Server) updateOrder(order *Order) error {
+	if order.Status == STATUS_PENDING || order.Status == STATUS_READY {
+		if time.Now().After(order.Expires) {
+			order.Status = STATUS_INVALID
+			order.Error = unauthorized("the order expired")
+		} else {
+			order.Status = STATUS_READY
+			for _, id := range order.Authorizations {
+				authz := new(Authorization)
+				if found, err := s.store.load("authz", id, authz); err != nil {
+					return serverInternal(err)
+				} else if !found {
+					return notFound("authorization")
+				}
+				if authz.Status == STATUS_PENDING && time.Now().After(authz.Expires) {
+					authz.Status = STATUS_EXPIRED
+				}
+				if authz.Status == STATUS_PENDING {
+					order.Status = STATUS_PENDING
+				} else if authz.Status != STATUS_VALID {
+					order.Status = STATUS_INVALID
+					order.Error = unauthorized("the authorization for '%s' is %s", authz.Identifier.Value, authz.Status)
+					break
+				}
+			}
+		}
+	}
+	if err := s.store.save("order", order.Id, order); err != nil {
+		return serverInternal(err)
+	}
+	return nil
+}
+
+// newAuthorization creates the authorization with the challenges that are
+// supported for the identifier, with the trust all option the authorization
+// will be valid without any validation.
+func (s *Server) newAuthorization(account string, identifier Identifier) *Authorization {
+	authz := &Authorization{
+		Id:         newId(),
+		Account:    account,
+		Status:     STATUS_PENDING,
+		Expires:    time.Now().Add(objectLifetime).UTC(),
+		Identifier: identifier,
+		Challenges: make([]*Challenge, 0),
+	}
+	types := []string{CHALLENGE_HTTP_01, CHALLENGE_DNS_01}
+	if identifier.Type == IDENTIFIER_IP {
+		types = []string{CHALLENGE_HTTP_01}
+	} else if strings.HasPrefix(identifier.Value, "*.") {
+		authz.Wildcard = true
+		authz.Identifier.Value = identifier.Value[2:]
+		types = []string{CHALLENGE_DNS_01}
+	}
+	for _, name := range types {
+		token := make([]byte, 32)
+		rand.Read(token)
+		authz.Challenges = append(authz.Challenges, &Challenge{Type: name, Token: base64.RawURLEncoding.EncodeToString(token), Status: STATUS_PENDING})
+	}
+	if s.conf.TrustAll {
+		now := time.Now().UTC()
+		authz.Status = STATUS_VALID
+		authz.Challenges[0].Status, authz.Challenges[0].Validated = STATUS_VALID, &now
+	}
+	return authz
+}
+
+// checkIdentifiers will normalize the identifiers and check them against
+// the policy and name constraints of the CA.
+func (s *Server) checkIdentifiers(list []Identifier) ([]Identifier, error) {
+	if len(list) == 0 {
+		return nil, malformed("the order has no identifiers")
+	}
+	identifiers, hosts := make([]Identifier, 0), make([]string, 0)
+	for _, identifier := range list {
+		switch identifier.Type {
+		case IDENTIFIER_DNS:
+			identifier.Value = strings.TrimSuffix(strings.ToLower(identifier.Value), ".")
+			if !validDomain.MatchString(identifier.Value) || net.ParseIP(identifier.Value) != nil {
+				return nil, NewProblem(ERROR_REJECTED_IDENTIFIER, http.StatusBadRequest, "invalid domain '%s'", identifier.Value)
+			}
+		case IDENTIFIER_IP:
+			ip := net.ParseIP(identifier.Value)
+			if ip == nil {
+				return nil, NewProblem(ERROR_REJECTED_IDENTIFIER, http.StatusBadRequest, "invalid ip address '%s'", identifier.Value)
+			}
+			identifier.Value = ip.String()
+		default:
+			return nil, NewProblem(ERROR_UNSUPPORTED_IDENTIFIER, http.StatusBadRequest, "unsupported identifier type '%s'", identifier.Type)
+		}
+		var exists bool
+		for _, host := range hosts {
+			exists = exists || host == identifier.Value
+		}
+		if !exists {
+			identifiers = append(identifiers, identifier)
+			hosts = append(hosts, identifier.Value)
+		}
+	}
+	if err := s.manager.GetPolicy().CheckHosts(hosts); err != nil {
+		return nil, NewProblem(ERROR_REJECTED_IDENTIFIER, http.StatusForbidden, "%s", err)
+	}
+	dns, ips := ca.SplitHosts(hosts)
+	if err := s.manager.CheckNameConstraints(s.manager.Get(s.manager.GetIssuer()), dns, ips, nil); err != nil {
+		return nil, NewProblem(ERROR_REJECTED_IDENTIFIER, http.StatusForbidden, "%s", err)
+	}
+	return identifiers, nil
+}
+
+// issue will sign the csr with the issuer of the CA and persist the certificate
+func (s *Server) issue(csr *x509.CertificateRequest, order *Order) (*storage.StorageKey, error) {
+	profile := s.manager.GetProfile(s.conf.Profile)
+	cert, err := s.manager.NewCertificate(csr, s.manager.Get(s.manager.GetIssuer()), profile, order.getValidity())
+	if err != nil {
+		if _, ok := err.(*ca.PolicyError); ok {
+			return nil, NewProblem(ERROR_BAD_CSR, http.StatusForbidden, "%s", err)
+		}
+		return nil, serverInternal(err)
+	}
+	// the request is saved so the certificate can be renewed without a new key
+	record := s.manager.NewRecord()
+	record.SetCertificateRequest(csr)
+	record.SetCertificate(cert)
+	if profile.Name != config.DEFAULT_PROFILE {
+		record.SetProfile(profile.Name)
+	}
+	key, err := s.manager.Save(record)
+	if err != nil {
+		return nil, serverInternal(err)
+	}
+	return key, nil
+}
+
+// checkCsr checks if the csr requests exactly the identifiers of the order
+// and will set the common name to the first identifier when not given.
+func checkCsr(csr *x509.CertificateRequest, identifiers []Identifier) error {
+	expected, requested := make([]string, 0), make([]string, 0)
+	for _, identifier := range identifiers {
+		value := identifier.Value
+		if identifier.Type == IDENTIFIER_IP {
+			value = net.ParseIP(value).String()
+		}
+		expected = append(expected, value)
+	}
+	for _, name := range csr.DNSNames {
+		if value := strings.ToLower(name); !inList(value, requested) {
+			requested = append(requested, value)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if value := ip.String(); !inList(value, requested) {
+			requested = append(requested, value)
+		}
+	}
+	sort.Strings(expected)
+	sort.Strings(requested)
+	if strings.Join(expected, ",") != strings.Join(requested, ",") {
+		return NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "the csr should request the identifiers %s, got %s", strings.Join(expected, ", "), strings.Join(requested, ", "))
+	}
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "the csr should only request dns names and ip addresses")
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn == "" {
+		csr.Subject.CommonName = identifiers[0].Value
+	} else if !inList(cn, expected) {
+		return NewProblem(ERROR_BAD_CSR, http.StatusBadRequest, "the common name '%s' is not one of the identifiers", csr.Subject.CommonName)
+	}
+	return nil
+}
+
+// checkContact checks if the contacts are (rfc8555 7.3) mailto urls
+func checkContact(list []string) error {
+	for _, contact := range list {
+		if !strings.HasPrefix(contact, "mailto:") || strings.ContainsAny(contact, ",?") {
+			return NewProblem(ERROR_UNSUPPORTED_CONTACT, http.StatusBadRequest, "unsupported contact '%s', only mailto: is supported", contact)
+		}
+	}
+	return nil
+}
+
+func inList(value string, list []string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/controller"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
+	"github.com/pbergman/logger"
+	"github.com/pbergman/logger/handlers"
+	"golang.org/x/crypto/acme"
+)
+
+// newTestServer starts the acme endpoints for a CA that only has an
+// acme config, the returned client has a new (unregistered) key.
+func newTestServer(t *testing.T, update func(*config.Config)) *acme.Client {
+	conf := new(config.Config)
+	util.SetDefaults(conf)
+	conf.Path = t.TempDir()
+	conf.CaNotAfter = [3]int{1, 0, 0}
+	conf.PemNotAfter = [3]int{0, 3, 0}
+	authority := &config.CaConfig{Name: config.DEFAULT_CA, Subject: &pkix.Name{CommonName: "example CA"}, Intermediates: 1}
+	authority.Key.Type = ca.KEY_TYPE_ECDSA
+	conf.Authorities = []*config.CaConfig{authority}
+	server := httptest.NewServer(nil)
+	t.Cleanup(server.Close)
+	conf.Url = server.URL
+	if update != nil {
+		update(conf)
+	}
+	registry, err := ca.NewRegistry(conf, func(name string) storage.Storage {
+		return storage.NewDiskStorage(t.TempDir(), &conf.Key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.NewLogger("test", handlers.NewWriterHandler(ioutil.Discard, logger.ERROR))
+	server.Config.Handler = router.NewRouter(log, controller.NewAcme(registry, conf))
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return &acme.Client{Key: key, DirectoryURL: server.URL + "/acme/directory"}
+}
+
+func newCsr(t *testing.T, hosts ...string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.CertificateRequest{DNSNames: hosts}
+	raw, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestServer_Http01(t *testing.T) {
+	var client *acme.Client
+	// the http-01 challenge responder of the client
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+		response, _ := client.HTTP01ChallengeResponse(token)
+		w.Write([]byte(response))
+	}))
+	defer responder.Close()
+	_, port, _ := net.SplitHostPort(responder.Listener.Addr().String())
+	client = newTestServer(t, func(conf *config.Config) {
+		conf.Acme.HttpPort, _ = strconv.Atoi(port)
+	})
+	ctx := context.Background()
+
+	if _, err := client.Register(ctx, &acme.Account{Contact: []string{"mailto:dev@example.com"}}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != acme.StatusPending || len(order.AuthzURLs) != 1 {
+		t.Fatalf("expected a pending order with 1 authorization, got %s with %d", order.Status, len(order.AuthzURLs))
+	}
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, challenge := range authz.Challenges {
+		if challenge.Type == "http-01" {
+			if _, err := client.Accept(ctx, challenge); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		t.Fatal(err)
+	}
+
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		t.Fatal(err)
+	}
+
+	// the csr should request the identifiers of the order
+	if _, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, newCsr(t, "localhost", "example.com"), true); err == nil {
+		t.Fatal("expected an error for a csr with other identifiers")
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, newCsr(t, "localhost"), true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chain) != 2 {
+		t.Fatalf("expected the certificate and intermediate, got %d certificates", len(chain))
+	}
+
+	cert, err := x509.ParseCertificate(chain[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "localhost" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "localhost" {
+		t.Fatalf("unexpected certificate %s %v", cert.Subject.CommonName, cert.DNSNames)
+	}
+
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatal("expected the certificate to be issued with the server profile")
+	}
+}
+
+func TestServer_TrustAll(t *testing.T) {
+	client := newTestServer(t, func(conf *config.Config) {
+		conf.Acme.TrustAll = true
+	})
+	ctx := context.Background()
+
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("*.dev.example.com"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != acme.StatusReady {
+		t.Fatalf("expected a ready order, got %s", order.Status)
+	}
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !authz.Wildcard || authz.Identifier.Value != "dev.example.com" || len(authz.Challenges) != 1 || authz.Challenges[0].Type != "dns-01" {
+		t.Fatal("expected a wildcard authorization with only the dns-01 challenge")
+	}
+
+	if _, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, newCsr(t, "*.dev.example.com"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second registration with the same key should return the existing account
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != acme.ErrAccountAlreadyExists {
+		t.Fatalf("expected the account to exist, got %v", err)
+	}
+}
+
+func TestServer_RejectedIdentifier(t *testing.T) {
+	client := newTestServer(t, func(conf *config.Config) {
+		policy := &config.PolicyConfig{AllowWildcard: true, DeniedDomains: []string{"*.prod.example.com"}, MinRsaBits: 2048, MaxRsaBits: 8192, MinEcdsaBits: 256, MaxEcdsaBits: 521}
+		conf.Policies = []*config.PolicyConfig{policy}
+	})
+	ctx := context.Background()
+
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.AuthorizeOrder(ctx, acme.DomainIDs("api.prod.example.com"))
+
+	if e, ok := err.(*acme.Error); !ok || e.ProblemType != "urn:ietf:params:acme:error:rejectedIdentifier" {
+		t.Fatalf("expected a rejected identifier, got %v", err)
+	}
+
+	if _, err := client.AuthorizeOrder(ctx, []acme.AuthzID{{Type: "email", Value: "dev@example.com"}}); err == nil {
+		t.Fatal("expected an error for an unsupported identifier")
+	}
+}
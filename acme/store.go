@@ -0,0 +1,67 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// the ids used for the store file names
+var validId = regexp.MustCompile(`^[a-f0-9]+$`)
+
+// store persists the accounts, orders and authorizations as json files
+// in a directory per kind, it does no locking so the server should make
+// sure that no concurrent writes are done for the same object.
+type store struct {
+	path string
+}
+
+func newStore(path string) *store {
+	for _, kind := range []string{"account", "order", "authz"} {
+		// noop function so we don`t check errors
+		os.MkdirAll(filepath.Join(path, kind), 0700)
+	}
+	return &store{path: path}
+}
+
+// load will return false when the object does not exists
+func (s *store) load(kind, id string, v interface{}) (bool, error) {
+	if !validId.MatchString(id) {
+		return false, nil
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(s.path, kind, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+func (s *store) save(kind, id string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Join(s.path, kind), "")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(raw); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.path, kind, id+".json"))
+}
+
+func newId() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,128 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbergman/caserver/config"
+)
+
+// the max time used for validating a challenge
+const validationTimeout = 10 * time.Second
+
+// validator will check the http-01 and dns-01 challenges (rfc8555 8.3 and 8.4)
+type validator struct {
+	port     int
+	resolver *net.Resolver
+	client   *http.Client
+}
+
+func newValidator(conf *config.AcmeConfig) *validator {
+	v := &validator{
+		port:     conf.HttpPort,
+		resolver: net.DefaultResolver,
+	}
+	v.client = &http.Client{
+		Timeout:       validationTimeout,
+		CheckRedirect: v.checkRedirect,
+	}
+	if conf.Resolver != "" {
+		v.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, network, conf.Resolver)
+			},
+		}
+	}
+	return v
+}
+
+// checkRedirect will only follow the redirects to the host of the identifier
+// on the http(s) ports (rfc8555 8.3) so the validation can not be used by an
+// account to make requests to other (internal) hosts or services.
+func (v *validator) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		return fmt.Errorf("redirect to an other host '%s' is not allowed", req.URL.Hostname())
+	}
+	switch port := req.URL.Port(); req.URL.Scheme {
+	case "http":
+		if port == "" || port == "80" || port == strconv.Itoa(v.port) {
+			return nil
+		}
+	case "https":
+		if port == "" || port == "443" {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect to '%s://%s' is not allowed, only the ports 80 and 443 can be used", req.URL.Scheme, req.URL.Host)
+}
+
+// Validate will check the challenge for the identifier and return
+// a problem when the expected key authorization was not found.
+func (v *validator) Validate(challenge *Challenge, identifier Identifier, keyAuthorization string) *Problem {
+	switch challenge.Type {
+	case CHALLENGE_HTTP_01:
+		return v.http01(identifier, challenge.Token, keyAuthorization)
+	case CHALLENGE_DNS_01:
+		return v.dns01(identifier, keyAuthorization)
+	default:
+		return malformed("unsupported challenge type '%s'", challenge.Type)
+	}
+}
+
+func (v *validator) http01(identifier Identifier, token, keyAuthorization string) *Problem {
+	host := identifier.Value
+	if v.port != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(v.port))
+	} else if identifier.Type == IDENTIFIER_IP && net.ParseIP(host).To4() == nil {
+		host = "[" + host + "]"
+	}
+	url := "http://" + host + "/.well-known/acme-challenge/" + token
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return NewProblem(ERROR_CONNECTION, http.StatusBadRequest, "could not fetch %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return NewProblem(ERROR_INCORRECT_RESPONSE, http.StatusForbidden, "invalid response from %s: %d", url, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err != nil {
+		return NewProblem(ERROR_CONNECTION, http.StatusBadRequest, "could not read %s: %s", url, err)
+	}
+	if string(bytes.TrimSpace(body)) != keyAuthorization {
+		return NewProblem(ERROR_INCORRECT_RESPONSE, http.StatusForbidden, "the key authorization from %s did not match", url)
+	}
+	return nil
+}
+
+func (v *validator) dns01(identifier Identifier, keyAuthorization string) *Problem {
+	name := "_acme-challenge." + identifier.Value
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+	records, err := v.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return NewProblem(ERROR_DNS, http.StatusBadRequest, "could not lookup the TXT records of %s: %s", name, err)
+	}
+	sum := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+	return NewProblem(ERROR_INCORRECT_RESPONSE, http.StatusForbidden, "no TXT record of %s matched the key authorization", name)
+}
@@ -0,0 +1,33 @@
+package acme
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pbergman/caserver/config"
+)
+
+func TestValidator_CheckRedirect(t *testing.T) {
+	v := newValidator(&config.AcmeConfig{HttpPort: 8080})
+	via := []*http.Request{{URL: &url.URL{Scheme: "http", Host: "example.com:8080", Path: "/.well-known/acme-challenge/token"}}}
+
+	for location, allowed := range map[string]bool{
+		"http://example.com/challenge":            true,
+		"http://EXAMPLE.com:8080/challenge":       true,
+		"https://example.com/challenge":           true,
+		"https://example.com:443/challenge":       true,
+		"http://127.0.0.1/challenge":              false,
+		"http://169.254.169.254/latest/meta-data": false,
+		"http://other.example.com/challenge":      false,
+		"http://example.com:6379/challenge":       false,
+		"https://example.com:8443/challenge":      false,
+		"ftp://example.com/challenge":             false,
+	} {
+		target, _ := url.Parse(location)
+
+		if err := v.checkRedirect(&http.Request{URL: target}, via); (err == nil) != allowed {
+			t.Fatalf("expected the redirect to %s allowed to be %v got: %v", location, allowed, err)
+		}
+	}
+}
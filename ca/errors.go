@@ -0,0 +1,18 @@
+package ca
+
+import "fmt"
+
+// RequestError is returned when a request for a certificate is invalid,
+// like a validity that can not be issued or names that are not allowed
+// by the profile.
+type RequestError struct {
+	message string
+}
+
+func (r *RequestError) Error() string {
+	return r.message
+}
+
+func newRequestError(format string, args ...interface{}) error {
+	return &RequestError{message: fmt.Sprintf(format, args...)}
+}
@@ -1,8 +1,8 @@
 package ca
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -10,34 +10,44 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"math/big"
-	"net"
-	"os"
+	"net/url"
 	"time"
+
+	"github.com/pbergman/caserver/config"
 )
 
 type FactoryInterface interface {
-	NewCertificateAuthority(*rsa.PrivateKey, pkix.Name) (*x509.Certificate, error)
-	NewCertificateRequest(*rsa.PrivateKey, pkix.Name, []string) (*x509.CertificateRequest, error)
-	NewCertificate(*x509.CertificateRequest, *x509.Certificate, *rsa.PrivateKey) (*x509.Certificate, error)
+	NewCertificateAuthority(crypto.Signer, pkix.Name, *config.NameConstraints) (*x509.Certificate, error)
+	NewIntermediateCertificateAuthority(crypto.Signer, pkix.Name, *x509.Certificate, crypto.Signer, int, *config.NameConstraints) (*x509.Certificate, error)
+	NewCrossCertificate(*x509.Certificate, *x509.Certificate, crypto.Signer) (*x509.Certificate, error)
+	NewCertificateRequest(crypto.Signer, pkix.Name, []string, []string, []*url.URL) (*x509.CertificateRequest, error)
+	NewCertificate(*x509.CertificateRequest, *x509.Certificate, crypto.Signer, *CertificateOptions) (*x509.Certificate, error)
 }
 
-func NewFactory(pna, cna [3]int, serial *big.Int) FactoryInterface {
-	if serial == nil {
-		serial = new(big.Int)
-		// create a base id from the machine id
-		if file, err := os.Open("/etc/machine-id"); err == nil {
-			defer file.Close()
-			// should corresponds to a 16-byte value, that would be 32 byte hex string.
-			// see https://www.freedesktop.org/software/systemd/man/machine-id.html
-			buf := make([]byte, 32)
-			n, _ := file.Read(buf)
-			serial.SetBytes(buf[:n])
-		}
-	}
+// CertificateOptions holds the extra (optional) properties
+// for creating a new certificate from a request.
+type CertificateOptions struct {
+	// the urls where the CRL of the issuer can be found
+	CRLDistributionPoints []string
+	// the urls of the OCSP responder and where the
+	// certificate of the issuer can be found
+	OCSPServer            []string
+	IssuingCertificateURL []string
+	// the profile that defines the usage of the certificate
+	Profile *config.ProfileConfig
+	// the validity of the certificate, when zero the default
+	// validity is used and the not after is limited by max.
+	NotBefore   time.Time
+	NotAfter    time.Time
+	MaxNotAfter time.Time
+	// the (requested) extensions that will be added as is
+	ExtraExtensions []pkix.Extension
+}
+
+func NewFactory(pna, cna [3]int) FactoryInterface {
 	return &factory{
-		pna:    &pna,
-		cna:    &cna,
-		serial: serial,
+		pna: &pna,
+		cna: &cna,
 	}
 }
 
@@ -49,62 +59,119 @@ type factory struct {
 	pna *[3]int
 	// same as pna but for the CA certificate
 	cna *[3]int
-	// the base serial used for creating new
-	// serial numbers
-	serial *big.Int
 }
 
-func (f *factory) init() {
-	if f.serial == nil {
-		f.serial = new(big.Int)
+// NewCertificateAuthority creates Certificate Authority using the given private
+// key and (optional) name constraints and returns a certificate in DER encoding.
+func (f factory) NewCertificateAuthority(key crypto.Signer, subject pkix.Name, constraints *config.NameConstraints) (*x509.Certificate, error) {
+	f.checkSubject(&subject)
+	ski, err := f.createSubjectKeyId(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	serial, err := f.newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-600).UTC(),
+		NotAfter:              time.Now().AddDate((*f.cna)[0], (*f.cna)[1], (*f.cna)[2]).UTC(),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            -1,
+		SubjectKeyId:          ski,
 	}
-	// create a base id from the machine id
-	if file, err := os.Open("/etc/machine-id"); err == nil {
-		defer file.Close()
-		// should corresponds to a 16-byte value, that would be 32 byte hex string.
-		// see https://www.freedesktop.org/software/systemd/man/machine-id.html
-		buf := make([]byte, 32)
-		n, _ := file.Read(buf)
-		f.serial.SetBytes(buf[:n])
+	setNameConstraints(&tmpl, constraints)
+	raw, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
 	}
+	return x509.ParseCertificate(raw)
 }
 
-// NewCertificateAuthority creates Certificate Authority using the
-// given private key and returns a certificate in DER encoding.
-func (f factory) NewCertificateAuthority(key *rsa.PrivateKey, subject pkix.Name) (*x509.Certificate, error) {
+// NewIntermediateCertificateAuthority creates a Certificate Authority that
+// is signed by the given parent, the pathLen is the number of intermediate
+// certificates that may follow this certificate in the chain.
+func (f factory) NewIntermediateCertificateAuthority(key crypto.Signer, subject pkix.Name, parent *x509.Certificate, parentKey crypto.Signer, pathLen int, constraints *config.NameConstraints) (*x509.Certificate, error) {
 	f.checkSubject(&subject)
-	ski, err := f.createSubjectKeyId(key.PublicKey)
+	ski, err := f.createSubjectKeyId(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	serial, err := f.newSerialNumber()
 	if err != nil {
 		return nil, err
 	}
 	tmpl := x509.Certificate{
-		SerialNumber:          f.newSerialNumber(nil),
+		SerialNumber:          serial,
 		Subject:               subject,
 		NotBefore:             time.Now().Add(-600).UTC(),
 		NotAfter:              time.Now().AddDate((*f.cna)[0], (*f.cna)[1], (*f.cna)[2]).UTC(),
-		KeyUsage:              x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
-		IsCA:         true,
-		MaxPathLen:   0,
-		SubjectKeyId: ski,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+		SubjectKeyId:          ski,
+	}
+	setNameConstraints(&tmpl, constraints)
+	// an intermediate can not outlive the certificate that signed it
+	if tmpl.NotAfter.After(parent.NotAfter) {
+		tmpl.NotAfter = parent.NotAfter
 	}
-	raw, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	raw, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, key.Public(), parentKey)
 	if err != nil {
 		return nil, err
 	}
 	return x509.ParseCertificate(raw)
 }
 
-func (f factory) NewCertificateRequest(key *rsa.PrivateKey, subject pkix.Name, hosts []string) (*x509.CertificateRequest, error) {
-	f.checkSubject(&subject)
-	tmpl := &x509.CertificateRequest{Subject: subject}
-	for _, host := range hosts {
-		if ip := net.ParseIP(host); ip != nil {
-			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
-		} else {
-			tmpl.DNSNames = append(tmpl.DNSNames, host)
-		}
+// NewCrossCertificate will sign the (root) certificate with the given parent
+// so clients that only trust the parent will also trust the certificate.
+func (f factory) NewCrossCertificate(cert *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, error) {
+	serial, err := f.newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               cert.Subject,
+		NotBefore:             time.Now().Add(-600).UTC(),
+		NotAfter:              cert.NotAfter,
+		KeyUsage:              cert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		SubjectKeyId:          cert.SubjectKeyId,
+		// should be set explicit because the subject and issuer will
+		// be the same when the subject of the CA did not change.
+		AuthorityKeyId:              parent.SubjectKeyId,
+		PermittedDNSDomainsCritical: cert.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         cert.PermittedDNSDomains,
+		ExcludedDNSDomains:          cert.ExcludedDNSDomains,
+		PermittedIPRanges:           cert.PermittedIPRanges,
+		ExcludedIPRanges:            cert.ExcludedIPRanges,
+		PermittedEmailAddresses:     cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      cert.ExcludedEmailAddresses,
 	}
+	if tmpl.NotAfter.After(parent.NotAfter) {
+		tmpl.NotAfter = parent.NotAfter
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, cert.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(raw)
+}
+
+func (f factory) NewCertificateRequest(key crypto.Signer, subject pkix.Name, hosts []string, emails []string, uris []*url.URL) (*x509.CertificateRequest, error) {
+	f.checkSubject(&subject)
+	tmpl := &x509.CertificateRequest{Subject: subject, EmailAddresses: emails, URIs: uris}
+	tmpl.DNSNames, tmpl.IPAddresses = SplitHosts(hosts)
 	raw, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
 	if err != nil {
 		return nil, err
@@ -112,21 +179,59 @@ func (f factory) NewCertificateRequest(key *rsa.PrivateKey, subject pkix.Name, h
 	return x509.ParseCertificateRequest(raw)
 }
 
-func (f factory) NewCertificate(csr *x509.CertificateRequest, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, error) {
+func (f factory) NewCertificate(csr *x509.CertificateRequest, caCert *x509.Certificate, caKey crypto.Signer, options *CertificateOptions) (*x509.Certificate, error) {
 	f.checkSubject(&csr.Subject)
-	ski, err := f.createSubjectKeyId(*csr.PublicKey.(*rsa.PublicKey))
+	ski, err := f.createSubjectKeyId(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := f.newSerialNumber()
 	if err != nil {
 		return nil, err
 	}
 	tmpl := &x509.Certificate{
-		SerialNumber: f.newSerialNumber(caCert),
-		Subject:      csr.Subject,
-		NotBefore:    time.Now().Add(-600).UTC(),
-		NotAfter:     time.Now().AddDate((*f.pna)[0], (*f.pna)[1], (*f.pna)[2]).UTC(),
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		SubjectKeyId: ski,
-		DNSNames:     csr.DNSNames,
-		IPAddresses:  csr.IPAddresses,
+		SerialNumber:   serial,
+		Subject:        csr.Subject,
+		NotBefore:      time.Now().Add(-600).UTC(),
+		NotAfter:       time.Now().AddDate((*f.pna)[0], (*f.pna)[1], (*f.pna)[2]).UTC(),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		SubjectKeyId:   ski,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
+	}
+	if options != nil {
+		tmpl.ExtraExtensions = options.ExtraExtensions
+		tmpl.CRLDistributionPoints = options.CRLDistributionPoints
+		tmpl.OCSPServer = options.OCSPServer
+		tmpl.IssuingCertificateURL = options.IssuingCertificateURL
+		if !options.NotBefore.IsZero() {
+			tmpl.NotBefore = options.NotBefore.UTC()
+		}
+		if !options.NotAfter.IsZero() {
+			tmpl.NotAfter = options.NotAfter.UTC()
+		}
+		if !options.MaxNotAfter.IsZero() && tmpl.NotAfter.After(options.MaxNotAfter) {
+			tmpl.NotAfter = options.MaxNotAfter.UTC()
+		}
+		if profile := options.Profile; profile != nil {
+			tmpl.KeyUsage = profile.KeyUsage
+			tmpl.ExtKeyUsage = profile.ExtKeyUsage
+			tmpl.BasicConstraintsValid = profile.BasicConstraints
+			if max := profile.MaxValidity; max != [3]int{} {
+				if limit := time.Now().AddDate(max[0], max[1], max[2]).UTC(); tmpl.NotAfter.After(limit) {
+					tmpl.NotAfter = limit
+				}
+			}
+		}
+	}
+	// a certificate can not be valid longer than its issuer
+	if tmpl.NotAfter.After(caCert.NotAfter) {
+		tmpl.NotAfter = caCert.NotAfter.UTC()
+	}
+	if !tmpl.NotAfter.After(tmpl.NotBefore) {
+		return nil, newRequestError("invalid validity, the not after (%s) should be after the not before (%s)", tmpl.NotAfter.Format(time.RFC3339), tmpl.NotBefore.Format(time.RFC3339))
 	}
 	raw, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, csr.PublicKey, caKey)
 	if err != nil {
@@ -135,6 +240,20 @@ func (f factory) NewCertificate(csr *x509.CertificateRequest, caCert *x509.Certi
 	return x509.ParseCertificate(raw)
 }
 
+// setNameConstraints will add the name constraints as critical extension
+func setNameConstraints(tmpl *x509.Certificate, constraints *config.NameConstraints) {
+	if constraints == nil || constraints.IsEmpty() {
+		return
+	}
+	tmpl.PermittedDNSDomainsCritical = true
+	tmpl.PermittedDNSDomains = constraints.PermittedDNS
+	tmpl.ExcludedDNSDomains = constraints.ExcludedDNS
+	tmpl.PermittedIPRanges = constraints.PermittedIPs
+	tmpl.ExcludedIPRanges = constraints.ExcludedIPs
+	tmpl.PermittedEmailAddresses = constraints.PermittedEmails
+	tmpl.ExcludedEmailAddresses = constraints.ExcludedEmails
+}
+
 func (a *factory) checkSubject(name *pkix.Name) {
 	if name.SerialNumber == "" {
 		if buf, err := json.Marshal(name); err == nil {
@@ -144,26 +263,47 @@ func (a *factory) checkSubject(name *pkix.Name) {
 	}
 }
 
-// newSerialNumber will generate a unique serial number for a certificate
-// based on the machine id (when available), time from now and root pem
-func (a *factory) newSerialNumber(root *x509.Certificate) *big.Int {
-	serial := new(big.Int)
-	serial.SetBytes(a.serial.Bytes())
-	if root != nil {
-		return serial.Add(big.NewInt((time.Now().Unix()+int64(1))-root.NotBefore.UnixNano()), serial)
-	} else {
-		return serial.Add(big.NewInt(time.Now().Unix()), serial)
+// newSerialNumber will generate a random positive serial number of 127 bits,
+// rfc5280 (4.1.2.2) allows serials up to 20 octets and the CA/Browser forum
+// requires at least 64 bits of randomness.
+func (a *factory) newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	// zero is not a valid serial number
+	if serial.Sign() == 0 {
+		return a.newSerialNumber()
 	}
+	return serial, nil
 }
 
-// createSubjectKeyId will create a byte slice that represents
-// a (SHA-1 hash value) ASN.1 encoding of a public key.
-func (f factory) createSubjectKeyId(key rsa.PublicKey) ([]byte, error) {
-	buf, err := asn1.Marshal(key)
+// createSubjectKeyId will create a byte slice that represents the SHA-1
+// hash of the subjectPublicKey bit string (see rfc5280 4.2.1.2), for
+// rsa keys this is the same as the ASN.1 encoding of the public key.
+func (f factory) createSubjectKeyId(key crypto.PublicKey) ([]byte, error) {
+	buf, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := subjectPublicKey(buf)
 	if err != nil {
 		return nil, err
 	}
 	hasher := sha1.New()
-	hasher.Write(buf)
+	hasher.Write(raw)
 	return hasher.Sum(nil), nil
 }
+
+// subjectPublicKey returns the public key bit string of
+// the DER encoded SubjectPublicKeyInfo structure.
+func subjectPublicKey(spki []byte) ([]byte, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, err
+	}
+	return info.PublicKey.Bytes, nil
+}
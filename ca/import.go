@@ -0,0 +1,153 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/storage"
+)
+
+// ImportCertificateAuthority will create the manager for a CA with an existing
+// root certificate and key (see config.ImportConfig), this will fail when the
+// storage already holds an active root certificate.
+func ImportCertificateAuthority(conf *config.Config, authority *config.CaConfig, db storage.Storage) (*Manager, error) {
+	if authority.Import.Cert == "" || authority.Import.Key == "" {
+		return nil, errors.New("missing certificate or key to import")
+	}
+	for _, key := range db.GetCa() {
+		if record, err := db.Open(key); err == nil && record.HasCertificate() && isSelfSigned(record.GetCertificate()) && !record.IsRetiring() {
+			return nil, fmt.Errorf("the CA '%s' already has a root certificate (%s)", authority.Name, key)
+		}
+	}
+	return NewManager(conf, authority, db)
+}
+
+// ReadCertificateAuthority will read the pem encoded certificate and the
+// (optionally encrypted) private key from the files of the import config.
+func ReadCertificateAuthority(conf config.ImportConfig) (*x509.Certificate, crypto.Signer, error) {
+	raw, err := os.ReadFile(conf.Cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := parseCertificatePem(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", conf.Cert, err)
+	}
+	if raw, err = os.ReadFile(conf.Key); err != nil {
+		return nil, nil, err
+	}
+	key, err := parsePrivateKeyPem(raw, []byte(conf.Password))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", conf.Key, err)
+	}
+	return cert, key, nil
+}
+
+// CheckCertificateAuthority checks if the certificate is a valid self signed
+// CA certificate that belongs to the key and can sign the given number of
+// intermediates.
+func CheckCertificateAuthority(cert *x509.Certificate, key crypto.Signer, intermediates int) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return errors.New("the certificate is not a CA certificate")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("the certificate is not allowed to sign certificates")
+	}
+	if !isSelfSigned(cert) {
+		return errors.New("only self signed (root) certificates can be imported")
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("the certificate is not valid at this time (%s - %s)", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	}
+	if (cert.MaxPathLen > 0 || cert.MaxPathLenZero) && intermediates > cert.MaxPathLen {
+		return fmt.Errorf("the certificate allows a path length of %d which is to short for %d intermediates", cert.MaxPathLen, intermediates)
+	}
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(cert.PublicKey) {
+		return errors.New("the private key does not match the certificate")
+	}
+	return nil
+}
+
+// importRoot will read, check and persist the root from the import config
+func (m *Manager) importRoot() (storage.Record, error) {
+	cert, key, err := ReadCertificateAuthority(m.authority.Import)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckCertificateAuthority(cert, key, m.authority.Intermediates); err != nil {
+		return nil, err
+	}
+	record := m.storage.NewRecord()
+	record.SetPrivateKey(key)
+	record.SetCertificate(cert)
+	if _, err := m.storage.Persist(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func parseCertificatePem(raw []byte) (*x509.Certificate, error) {
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return nil, errors.New("no pem encoded certificate found")
+}
+
+// parsePrivateKeyPem will decode the first private key block and supports
+// the PKCS #1, SEC 1 and PKCS #8 encoding with the (legacy) openssl pem
+// encryption or an encrypted PKCS #8 key.
+func parsePrivateKeyPem(raw []byte, password []byte) (crypto.Signer, error) {
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "ENCRYPTED PRIVATE KEY":
+			if len(password) == 0 {
+				return nil, errors.New("the private key is encrypted and no password was given")
+			}
+			data, err := decryptPKCS8(block.Bytes, password)
+			if err != nil {
+				return nil, err
+			}
+			return parsePrivateKey(data)
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+			data := block.Bytes
+			// the legacy encryption as used by `openssl rsa -aes256`
+			if x509.IsEncryptedPEMBlock(block) {
+				if len(password) == 0 {
+					return nil, errors.New("the private key is encrypted and no password was given")
+				}
+				var err error
+				if data, err = x509.DecryptPEMBlock(block, password); err != nil {
+					return nil, err
+				}
+			}
+			return parsePrivateKey(data)
+		}
+	}
+	return nil, errors.New("no pem encoded private key found")
+}
+
+func parsePrivateKey(raw []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(raw); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(raw); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, errors.New("could not parse the private key, wrong password?")
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
@@ -0,0 +1,122 @@
+package ca
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// rfc5958 section 3
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+// rfc8018 appendix A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// rfc8018 appendix A.2
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	Prf        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 will decrypt a PKCS #8 key that is encrypted with PBES2, as
+// created by `openssl genpkey -aes256` or `openssl pkcs8 -topk8`, and return
+// the DER encoded (unencrypted) PKCS #8 key.
+func decryptPKCS8(raw, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(raw, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.Prf.Algorithm) == 0, kdf.Prf.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.Prf.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.Prf.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported pseudo random function %s", kdf.Prf.Algorithm)
+	}
+	var size int
+	var block func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		size, block = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		size, block = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		size, block = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		size, block = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported encryption scheme %s", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	c, err := block(pbkdf2.Key(password, kdf.Salt, kdf.Iterations, size, prf))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != c.BlockSize() || len(info.Data) == 0 || len(info.Data)%c.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted private key")
+	}
+	data := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(data, info.Data)
+	// remove the PKCS #7 padding, a wrong password will (most likely) fail here
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > c.BlockSize() {
+		return nil, errors.New("could not decrypt the private key, wrong password?")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("could not decrypt the private key, wrong password?")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
@@ -0,0 +1,87 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+const (
+	KEY_TYPE_RSA     string = "rsa"
+	KEY_TYPE_ECDSA   string = "ecdsa"
+	KEY_TYPE_ED25519 string = "ed25519"
+)
+
+// KeyOptions describes the private key that should be generated
+// for a certificate (request). Bits is only used for rsa keys
+// and Curve only for ecdsa keys.
+type KeyOptions struct {
+	Type  string
+	Bits  int
+	Curve string
+}
+
+// NewKeyOptions returns the options that describe the given public key
+// so a new key can be generated that is equal to the existing key.
+func NewKeyOptions(key crypto.PublicKey) (KeyOptions, error) {
+	switch t := key.(type) {
+	case *rsa.PublicKey:
+		return KeyOptions{Type: KEY_TYPE_RSA, Bits: t.N.BitLen()}, nil
+	case *ecdsa.PublicKey:
+		return KeyOptions{Type: KEY_TYPE_ECDSA, Curve: t.Curve.Params().Name}, nil
+	case ed25519.PublicKey:
+		return KeyOptions{Type: KEY_TYPE_ED25519}, nil
+	default:
+		return KeyOptions{}, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// Generate will create a new private key based on the options.
+func (k KeyOptions) Generate() (crypto.Signer, error) {
+	switch k.GetType() {
+	case KEY_TYPE_RSA:
+		return rsa.GenerateKey(rand.Reader, k.Bits)
+	case KEY_TYPE_ECDSA:
+		curve, err := k.GetCurve()
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KEY_TYPE_ED25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Type)
+	}
+}
+
+// GetType returns the normalized key type
+func (k KeyOptions) GetType() string {
+	switch strings.ToLower(k.Type) {
+	case "", "rsa":
+		return KEY_TYPE_RSA
+	case "ec", "ecdsa":
+		return KEY_TYPE_ECDSA
+	case "ed25519":
+		return KEY_TYPE_ED25519
+	default:
+		return strings.ToLower(k.Type)
+	}
+}
+
+// GetCurve returns the elliptic curve for the configured name.
+func (k KeyOptions) GetCurve() (elliptic.Curve, error) {
+	switch strings.ToUpper(k.Curve) {
+	case "", "P-256", "P256", "PRIME256V1":
+		return elliptic.P256(), nil
+	case "P-384", "P384", "SECP384R1":
+		return elliptic.P384(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve '%s', expected P-256 or P-384", k.Curve)
+	}
+}
@@ -1,18 +1,31 @@
 package ca
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"bytes"
+	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pbergman/caserver/config"
 	"github.com/pbergman/caserver/storage"
 )
 
-func NewManager(config *config.Config, db storage.Storage) (*Manager, error) {
+func NewManager(config *config.Config, authority *config.CaConfig, db storage.Storage) (*Manager, error) {
 
-	manager := &Manager{db, NewFactory(config.PemNotAfter, config.CaNotAfter, nil), config, nil}
+	manager := &Manager{
+		storage:   db,
+		factory:   NewFactory(config.PemNotAfter, config.CaNotAfter),
+		config:    config,
+		authority: authority,
+		crls:      make(map[string][]byte),
+	}
 
 	if err := manager.Init(); err != nil {
 		return nil, err
@@ -25,7 +38,25 @@ type Manager struct {
 	storage storage.Storage
 	factory FactoryInterface
 	config  *config.Config
-	ca      *storage.StorageKey
+	// the config of the CA this manager represents
+	authority *config.CaConfig
+	// the (self signed) root certificate
+	ca *storage.StorageKey
+	// the intermediate certificates ordered from
+	// the root down to the issuer of certificates
+	intermediates []*storage.StorageKey
+	// the roots that are replaced by a rollover but
+	// should be trusted until they expire
+	retiring []*storage.StorageKey
+	// the DER encoded CRL`s by hex encoded subject key id of the issuer
+	crls map[string][]byte
+	lock sync.RWMutex
+	// the expiry status of the records at the last scan by record id
+	expiry     map[string]*ExpiryStatus
+	expiryLock sync.RWMutex
+	// the record of the ssh CA key, loaded (or created) on first use
+	sshCa   storage.Record
+	sshLock sync.Mutex
 }
 
 // Search will do a search based on the `CommonName` and return nil
@@ -40,6 +71,11 @@ func (m *Manager) Lookup(id string) storage.Record {
 	return record
 }
 
+// Name returns the name of the CA this manager represents
+func (m *Manager) Name() string {
+	return m.authority.Name
+}
+
 func (m *Manager) GetFactory() FactoryInterface {
 	return m.factory
 }
@@ -64,32 +100,211 @@ func (m *Manager) Remove(key *storage.StorageKey) error {
 	return m.storage.Remove(key)
 }
 
+// GetCa returns the key of the root certificate
 func (m *Manager) GetCa() *storage.StorageKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.ca
 }
 
+// GetCaBySubjectKeyId will return the CA record with the given hex
+// encoded subject key id or nil when not found.
+func (m *Manager) GetCaBySubjectKeyId(kid string) storage.Record {
+	for _, key := range m.storage.GetCa() {
+		if record := m.Get(key); record != nil && hex.EncodeToString(record.GetCertificate().SubjectKeyId) == kid {
+			if !isCrossCertificate(record) {
+				return record
+			}
+		}
+	}
+	return nil
+}
+
+// GetRoots returns the active root followed by the retiring
+// roots that are not expired yet.
+func (m *Manager) GetRoots() []storage.Record {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	roots := make([]storage.Record, 0)
+	if root := m.Get(m.ca); root != nil {
+		roots = append(roots, root)
+	}
+	for _, key := range m.retiring {
+		if root := m.Get(key); root != nil && root.GetCertificate().NotAfter.After(time.Now()) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// GetIssuer returns the key of the CA that is used to sign
+// certificates, this will be the last intermediate or the
+// root when no intermediates are configured.
+func (m *Manager) GetIssuer() *storage.StorageKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if c := len(m.intermediates); c > 0 {
+		return m.intermediates[c-1]
+	}
+	return m.ca
+}
+
+// GetChain will return the CA records that signed the certificate of the
+// given record, starting with the issuer. The root is only included when
+// it is the direct issuer of the certificate and when the root was cross
+// signed by a retiring root the cross certificate will be appended.
+func (m *Manager) GetChain(record storage.Record) []storage.Record {
+	chain := make([]storage.Record, 0)
+	if record == nil || !record.HasCertificate() {
+		return chain
+	}
+	cas, cross := make([]storage.Record, 0), make([]storage.Record, 0)
+	for _, key := range m.storage.GetCa() {
+		if ca := m.Get(key); ca != nil && ca.HasCertificate() {
+			if isCrossCertificate(ca) {
+				cross = append(cross, ca)
+			} else {
+				cas = append(cas, ca)
+			}
+		}
+	}
+	cert := record.GetCertificate()
+	for !isSelfSigned(cert) {
+		parent := findIssuer(cert, cas)
+		if parent == nil {
+			break
+		}
+		if len(chain) > 0 && isSelfSigned(parent.GetCertificate()) {
+			cert = parent.GetCertificate()
+			break
+		}
+		chain = append(chain, parent)
+		cert = parent.GetCertificate()
+	}
+	if isSelfSigned(cert) {
+		for _, record := range cross {
+			if c := record.GetCertificate(); c.NotAfter.After(time.Now()) && bytes.Equal(c.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+				chain = append(chain, record)
+			}
+		}
+	}
+	return chain
+}
+
 func (m *Manager) NewRecord() storage.Record {
 	return m.storage.NewRecord()
 }
 
-func (m *Manager) SignCertificateRequest(csr, ca storage.Record) error {
-	cert, err := m.factory.NewCertificate(csr.GetCertificateRequest(), ca.GetCertificate(), ca.GetPrivateKey())
+// GetProfile returns the certificate profile for the given name or nil
+// when not found, an empty name will return the default profile.
+func (m *Manager) GetProfile(name string) *config.ProfileConfig {
+	return m.config.GetProfile(name)
+}
+
+// GetPolicy returns the issuance policy of the CA
+func (m *Manager) GetPolicy() *Policy {
+	return NewPolicy(m.config.GetPolicy(m.Name()))
+}
+
+// NewCertificate will sign the certificate request with the given CA using the
+// given profile and validity, the default profile is used when profile is nil
+// and the default validity when validity is nil.
+func (m *Manager) NewCertificate(csr *x509.CertificateRequest, ca storage.Record, profile *config.ProfileConfig, validity *Validity) (*x509.Certificate, error) {
+	if profile == nil {
+		if profile = m.GetProfile(""); profile == nil {
+			return nil, errors.New("failed to find the default profile")
+		}
+	}
+	if err := CheckProfile(profile, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs); err != nil {
+		return nil, err
+	}
+	if err := m.GetPolicy().CheckRequest(csr); err != nil {
+		return nil, err
+	}
+	if err := m.CheckNameConstraints(ca, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses); err != nil {
+		return nil, err
+	}
+	options := m.getCertificateOptions(ca.GetCertificate(), profile)
+	options.ExtraExtensions = m.GetPolicy().CopyExtensions(csr)
+	if validity != nil {
+		notAfter, err := validity.getNotAfter(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		options.NotBefore = validity.NotBefore
+		options.NotAfter = notAfter
+	}
+	cert, err := m.factory.NewCertificate(csr, ca.GetCertificate(), ca.GetPrivateKey(), options)
+	if err != nil {
+		return nil, err
+	}
+	// the serial should be unique for the issuer to make
+	// the revocation (CRL and OCSP) of certificates reliable
+	if m.SearchSerial(cert.SerialNumber, ca.GetCertificate()) != nil {
+		return nil, fmt.Errorf("a certificate with serial number %x is already issued", cert.SerialNumber)
+	}
+	return cert, nil
+}
+
+// SearchSerial will return the record with the certificate that has the given
+// serial number and is signed by the given issuer or nil when not found.
+func (m *Manager) SearchSerial(serial *big.Int, issuer *x509.Certificate) storage.Record {
+	for _, record := range m.storage.SearchSerial(serial) {
+		if cert := record.GetCertificate(); bytes.Equal(cert.RawIssuer, issuer.RawSubject) && cert.CheckSignatureFrom(issuer) == nil {
+			return record
+		}
+	}
+	return nil
+}
+
+// getCertificateOptions returns the options for certificates issued by the given CA,
+// the CRL, OCSP and issuer urls are only added when the public url is configured
+// because an url based on the listen address is mostly not reachable by clients.
+func (m *Manager) getCertificateOptions(issuer *x509.Certificate, profile *config.ProfileConfig) *CertificateOptions {
+	options := &CertificateOptions{
+		Profile:     profile,
+		MaxNotAfter: m.config.GetPemMaxNotAfter(time.Now()),
+	}
+	if m.config.Url != "" {
+		kid := hex.EncodeToString(issuer.SubjectKeyId)
+		options.CRLDistributionPoints = []string{m.GetUrl("/crl/" + kid + ".crl")}
+		options.OCSPServer = []string{m.GetUrl("/ocsp")}
+		options.IssuingCertificateURL = []string{m.GetUrl("/issuer/" + kid + ".der")}
+	}
+	return options
+}
+
+// GetUrl returns the public url for the given path relative to the CA api endpoint
+func (m *Manager) GetUrl(path string) string {
+	if m.Name() == config.DEFAULT_CA {
+		return m.config.GetUrl() + "/api/v1/ca" + path
+	}
+	return m.config.GetUrl() + "/api/v1/ca/" + m.Name() + path
+}
+
+// SignCertificateRequest will sign the certificate request of the record with the
+// given CA, profile and validity and persist the certificate and profile name.
+func (m *Manager) SignCertificateRequest(csr, ca storage.Record, profile *config.ProfileConfig, validity *Validity) error {
+	cert, err := m.NewCertificate(csr.GetCertificateRequest(), ca, profile, validity)
 	if err != nil {
 		return err
 	}
 	csr.SetCertificate(cert)
+	if profile != nil && profile.Name != config.DEFAULT_PROFILE {
+		csr.SetProfile(profile.Name)
+	}
 	if _, err := m.storage.Persist(csr); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Manager) NewCertificateRequest(hosts []string, subject pkix.Name, bits int) (storage.Record, error) {
-	key, err := rsa.GenerateKey(rand.Reader, bits)
+func (m *Manager) NewCertificateRequest(hosts, emails []string, uris []*url.URL, subject pkix.Name, options KeyOptions) (storage.Record, error) {
+	key, err := options.Generate()
 	if err != nil {
 		return nil, err
 	}
-	csr, err := m.factory.NewCertificateRequest(key, subject, hosts)
+	csr, err := m.factory.NewCertificateRequest(key, subject, hosts, emails, uris)
 	if err != nil {
 		return nil, err
 	}
@@ -103,39 +318,163 @@ func (m *Manager) NewCertificateRequest(hosts []string, subject pkix.Name, bits
 	}
 }
 
-// Init will do some check and setups for the manager, this manager only supports on
-// active CA so if more than one is found it will return a error and it will create
-// new certificates if none were found.
+// Init will do some check and setups for the manager, this manager only supports one
+// active root CA so if more than one is found the newest is kept and the others are
+// marked as retiring and it will create a new (or imported, see config.ImportConfig)
+// root and the configured intermediates if none were found.
 func (m *Manager) Init() error {
 	if m.storage == nil {
 		return errors.New("missing storage interface")
 	}
-	if m.config == nil {
+	if m.config == nil || m.authority == nil {
 		return errors.New("missing config")
 	}
-	list := m.storage.GetCa()
-	switch len(list) {
+	roots, intermediates := make([]storage.Record, 0), make([]storage.Record, 0)
+	for _, key := range m.storage.GetCa() {
+		if record := m.Get(key); record != nil && record.HasCertificate() {
+			if isSelfSigned(record.GetCertificate()) {
+				roots = append(roots, record)
+			} else {
+				intermediates = append(intermediates, record)
+			}
+		}
+	}
+	active := make([]storage.Record, 0)
+	m.retiring = make([]*storage.StorageKey, 0)
+	for _, root := range roots {
+		if root.IsRetiring() {
+			m.retiring = append(m.retiring, root.GetId())
+		} else {
+			active = append(active, root)
+		}
+	}
+	switch len(active) {
 	case 0:
-		key, err := rsa.GenerateKey(rand.Reader, 2048)
-		if err != nil {
-			return err
+		create := m.newRoot
+		if m.authority.Import.Cert != "" {
+			create = m.importRoot
 		}
-		cert, err := m.factory.NewCertificateAuthority(key, *m.config.CaSubject)
+		record, err := create()
 		if err != nil {
 			return err
 		}
-		record := m.storage.NewRecord()
-		record.SetPrivateKey(key)
-		record.SetCertificate(cert)
-		if kid, err := m.storage.Persist(record); err != nil {
-			return err
-		} else {
-			m.ca = kid
-		}
+		m.ca = record.GetId()
+		active = append(active, record)
 	case 1:
-		m.ca = list[0]
+		m.ca = active[0].GetId()
 	default:
-		return errors.New("to many CA certificates found")
+		// more than one active root can only be the result of an interrupted
+		// rollover (from before the order was fixed), the newest root is kept
+		// active and the others are marked as retiring so they are trusted
+		// until they expire.
+		sort.Slice(active, func(i, j int) bool {
+			return active[i].GetCertificate().NotBefore.After(active[j].GetCertificate().NotBefore)
+		})
+		for _, root := range active[1:] {
+			root.SetRetiring(true)
+			if _, err := m.storage.Persist(root); err != nil {
+				return fmt.Errorf("too many CA certificates found, failed to mark '%s' as retiring (%s)", root.GetId(), err)
+			}
+			m.retiring = append(m.retiring, root.GetId())
+		}
+		m.ca = active[0].GetId()
+	}
+	keys, err := m.initIntermediates(active[0], intermediates)
+	if err != nil {
+		return err
+	}
+	m.intermediates = keys
+	return m.UpdateCRLs()
+}
+
+// newRoot will create and persist a new self signed root certificate
+func (m *Manager) newRoot() (storage.Record, error) {
+	record, err := m.createRoot()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.storage.Persist(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// createRoot will create a new self signed root certificate without persisting it
+func (m *Manager) createRoot() (storage.Record, error) {
+	key, err := KeyOptions(m.authority.Key).Generate()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := m.factory.NewCertificateAuthority(key, *m.authority.Subject, &m.authority.NameConstraints)
+	if err != nil {
+		return nil, err
+	}
+	record := m.storage.NewRecord()
+	record.SetPrivateKey(key)
+	record.SetCertificate(cert)
+	return record, nil
+}
+
+// initIntermediates will walk from the root down and collect the
+// intermediates that are found in storage and create the missing. On
+// error the keys that were collected (or created) so far are returned.
+func (m *Manager) initIntermediates(parent storage.Record, list []storage.Record) ([]*storage.StorageKey, error) {
+	keys := make([]*storage.StorageKey, 0)
+	for depth, total := 1, m.authority.Intermediates; depth <= total; depth++ {
+		var record storage.Record
+		for _, intermediate := range list {
+			if p := findIssuer(intermediate.GetCertificate(), []storage.Record{parent}); p != nil && intermediate.HasPrivateKey() {
+				record = intermediate
+				break
+			}
+		}
+		if record == nil {
+			key, err := KeyOptions(m.authority.Key).Generate()
+			if err != nil {
+				return keys, err
+			}
+			subject := *m.authority.Subject
+			subject.SerialNumber = ""
+			subject.CommonName = m.authority.Subject.CommonName + " Intermediate"
+			if total > 1 {
+				subject.CommonName += fmt.Sprintf(" %d", depth)
+			}
+			cert, err := m.factory.NewIntermediateCertificateAuthority(key, subject, parent.GetCertificate(), parent.GetPrivateKey(), total-depth, &m.authority.NameConstraints)
+			if err != nil {
+				return keys, err
+			}
+			record = m.storage.NewRecord()
+			record.SetPrivateKey(key)
+			record.SetCertificate(cert)
+			if _, err := m.storage.Persist(record); err != nil {
+				return keys, err
+			}
+		}
+		keys = append(keys, record.GetId())
+		parent = record
+	}
+	return keys, nil
+}
+
+// isCrossCertificate checks if the record is a root certificate
+// that is signed by an other root, see Manager.Rollover
+func isCrossCertificate(record storage.Record) bool {
+	return !record.HasPrivateKey() && !isSelfSigned(record.GetCertificate())
+}
+
+// isSelfSigned checks if the certificate is signed by its own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// findIssuer will return the record of the list that signed the given certificate
+func findIssuer(cert *x509.Certificate, list []storage.Record) storage.Record {
+	for _, record := range list {
+		if parent := record.GetCertificate(); parent != nil && bytes.Equal(cert.RawIssuer, parent.RawSubject) {
+			if cert.CheckSignatureFrom(parent) == nil {
+				return record
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,74 @@
+package ca
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
+)
+
+// Conflicts returns the records with a valid (not expired, revoked or renewed)
+// certificate that have alternative names that overlap with the given names.
+// The dns names are matched with the wildcard semantics of util.OverlapHost
+// and the ip addresses, email addresses and uris should be the same.
+func (m *Manager) Conflicts(dns []string, ips []net.IP, emails []string, uris []*url.URL) []storage.Record {
+	now := time.Now()
+	renewed := make(map[string]bool)
+	candidates := make([]storage.Record, 0)
+	m.Each(func(record storage.Record) bool {
+		if predecessor := record.GetPredecessor(); predecessor != nil {
+			renewed[predecessor.String()] = true
+		}
+		if record.IsCa() || record.IsRevoked() || !record.HasCertificate() || now.After(record.GetCertificate().NotAfter) {
+			return true
+		}
+		if overlapNames(record.GetCertificate(), dns, ips, emails, uris) {
+			candidates = append(candidates, record)
+		}
+		return true
+	})
+	conflicts := make([]storage.Record, 0, len(candidates))
+	for _, record := range candidates {
+		if !renewed[record.GetId().String()] {
+			conflicts = append(conflicts, record)
+		}
+	}
+	return conflicts
+}
+
+// overlapNames checks if one of the names overlaps with the alternative names of the certificate
+func overlapNames(cert *x509.Certificate, dns []string, ips []net.IP, emails []string, uris []*url.URL) bool {
+	for _, a := range cert.DNSNames {
+		for _, b := range dns {
+			if util.OverlapHost(a, b) {
+				return true
+			}
+		}
+	}
+	for _, a := range cert.IPAddresses {
+		for _, b := range ips {
+			if a.Equal(b) {
+				return true
+			}
+		}
+	}
+	for _, a := range cert.EmailAddresses {
+		for _, b := range emails {
+			if strings.EqualFold(a, b) {
+				return true
+			}
+		}
+	}
+	for _, a := range cert.URIs {
+		for _, b := range uris {
+			if a.String() == b.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package ca
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+// CheckNameConstraints will check the names against the name constraints of the
+// issuer and the CA certificates above it, so no certificate is signed that
+// would be rejected by clients that validate the name constraints.
+func (m *Manager) CheckNameConstraints(issuer storage.Record, dns []string, ips []net.IP, emails []string) error {
+	violations := new(PolicyError)
+	cas := make([]storage.Record, 0)
+	for _, key := range m.storage.GetCa() {
+		if record := m.Get(key); record != nil && record.HasCertificate() && !isCrossCertificate(record) {
+			cas = append(cas, record)
+		}
+	}
+	for cert := issuer.GetCertificate(); cert != nil; {
+		checkNameConstraints(violations, cert, dns, ips, emails)
+		if isSelfSigned(cert) {
+			break
+		}
+		if parent := findIssuer(cert, cas); parent != nil {
+			cert = parent.GetCertificate()
+		} else {
+			cert = nil
+		}
+	}
+	return violations.get()
+}
+
+func checkNameConstraints(violations *PolicyError, ca *x509.Certificate, dns []string, ips []net.IP, emails []string) {
+	for _, name := range dns {
+		if c := matchConstraint(name, ca.ExcludedDNSDomains, matchDNSConstraint); c != "" {
+			violations.add("name_constraints", "(%s) excludes the domain '%s'", c, name)
+		} else if len(ca.PermittedDNSDomains) > 0 && matchConstraint(name, ca.PermittedDNSDomains, matchDNSConstraint) == "" {
+			violations.add("name_constraints", "(%s) does not permit the domain '%s'", strings.Join(ca.PermittedDNSDomains, ", "), name)
+		}
+	}
+	for _, ip := range ips {
+		if network := matchNetwork(ip, ca.ExcludedIPRanges); network != nil {
+			violations.add("name_constraints", "(%s) excludes the ip address '%s'", network, ip)
+		} else if len(ca.PermittedIPRanges) > 0 && matchNetwork(ip, ca.PermittedIPRanges) == nil {
+			violations.add("name_constraints", "does not permit the ip address '%s'", ip)
+		}
+	}
+	for _, email := range emails {
+		if c := matchConstraint(email, ca.ExcludedEmailAddresses, matchEmailConstraint); c != "" {
+			violations.add("name_constraints", "(%s) excludes the email address '%s'", c, email)
+		} else if len(ca.PermittedEmailAddresses) > 0 && matchConstraint(email, ca.PermittedEmailAddresses, matchEmailConstraint) == "" {
+			violations.add("name_constraints", "(%s) does not permit the email address '%s'", strings.Join(ca.PermittedEmailAddresses, ", "), email)
+		}
+	}
+}
+
+// matchConstraint returns the first constraint that matches the name
+func matchConstraint(name string, constraints []string, match func(string, string) bool) string {
+	for _, constraint := range constraints {
+		if match(strings.ToLower(name), strings.ToLower(constraint)) {
+			return constraint
+		}
+	}
+	return ""
+}
+
+// matchDNSConstraint follows rfc5280 (4.2.1.10) where `example.com` matches
+// the domain and every sub domain and `.example.com` only the sub domains.
+func matchDNSConstraint(name, constraint string) bool {
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint) && len(name) > len(constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// matchEmailConstraint matches a mailbox (user@host), all mailboxes
+// on a host (host) or all mailboxes on sub domains (.host).
+func matchEmailConstraint(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return email == constraint
+	}
+	host := email[strings.LastIndex(email, "@")+1:]
+	return matchDNSConstraint(host, constraint)
+}
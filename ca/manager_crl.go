@@ -0,0 +1,98 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+// Revoke will mark the certificate of the record as revoked and
+// regenerates the CRL`s so the revocation is published directly.
+func (m *Manager) Revoke(record storage.Record, reason int) error {
+	if record.IsCa() {
+		return errors.New("a certificate authority can not be revoked")
+	}
+	if !record.HasCertificate() {
+		return errors.New("record has no certificate to revoke")
+	}
+	if record.IsRevoked() {
+		return errors.New("certificate is already revoked")
+	}
+	record.SetRevocation(&storage.Revocation{Time: time.Now().UTC(), Reason: reason})
+	if _, err := m.storage.Persist(record); err != nil {
+		return err
+	}
+	return m.UpdateCRLs()
+}
+
+// GetCRL returns the DER encoded CRL of the CA with the given (hex encoded)
+// subject key id, when empty the CRL of the active issuer will be returned.
+func (m *Manager) GetCRL(kid string) ([]byte, error) {
+	if kid == "" {
+		if issuer := m.Get(m.GetIssuer()); issuer != nil {
+			kid = hex.EncodeToString(issuer.GetCertificate().SubjectKeyId)
+		}
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if crl, ok := m.crls[kid]; ok {
+		return crl, nil
+	}
+	return nil, errors.New("no CRL found for " + kid)
+}
+
+// UpdateCRLs will (re)generate the CRL`s for every CA that can sign.
+func (m *Manager) UpdateCRLs() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.updateCRLs()
+}
+
+func (m *Manager) updateCRLs() error {
+	issuers, revoked := make([]storage.Record, 0), make([]storage.Record, 0)
+	err := m.storage.Each(func(record storage.Record) bool {
+		if record.IsCa() {
+			if record.HasPrivateKey() && record.GetCertificate().KeyUsage&x509.KeyUsageCRLSign != 0 {
+				issuers = append(issuers, record)
+			}
+		} else if record.IsRevoked() && record.HasCertificate() {
+			revoked = append(revoked, record)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	now, interval := time.Now().UTC(), m.config.CrlInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	for _, issuer := range issuers {
+		list := &x509.RevocationList{
+			Number:     big.NewInt(now.UnixNano()),
+			ThisUpdate: now,
+			NextUpdate: now.Add(2 * interval),
+		}
+		for _, record := range revoked {
+			if cert := record.GetCertificate(); bytes.Equal(cert.RawIssuer, issuer.GetCertificate().RawSubject) && cert.CheckSignatureFrom(issuer.GetCertificate()) == nil {
+				list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{
+					SerialNumber:   cert.SerialNumber,
+					RevocationTime: record.GetRevocation().Time,
+					ReasonCode:     record.GetRevocation().Reason,
+				})
+			}
+		}
+		raw, err := x509.CreateRevocationList(rand.Reader, list, issuer.GetCertificate(), issuer.GetPrivateKey())
+		if err != nil {
+			return err
+		}
+		m.crls[hex.EncodeToString(issuer.GetCertificate().SubjectKeyId)] = raw
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package ca
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+const (
+	EXPIRY_VALID    string = "valid"
+	EXPIRY_EXPIRING string = "expiring"
+	EXPIRY_EXPIRED  string = "expired"
+)
+
+// ExpiryStatus is the expiry status of a record at the last scan
+type ExpiryStatus struct {
+	Status   string    `json:"status"`
+	NotAfter time.Time `json:"not_after"`
+	Checked  time.Time `json:"checked"`
+}
+
+// GetExpiryStatus returns the (EXPIRY_*) status of the certificate of the
+// record for the given time and the period before expiry that is expiring.
+func GetExpiryStatus(record storage.Record, now time.Time, within time.Duration) string {
+	switch notAfter := record.GetCertificate().NotAfter; {
+	case now.After(notAfter):
+		return EXPIRY_EXPIRED
+	case now.Add(within).After(notAfter):
+		return EXPIRY_EXPIRING
+	default:
+		return EXPIRY_VALID
+	}
+}
+
+// Expiring returns the records with a certificate (including the CA`s) that are expired
+// or will expire within the given duration, ordered by expiry. The revoked and
+// renewed certificates are ignored because these are not used anymore.
+func (m *Manager) Expiring(within time.Duration) []storage.Record {
+	return m.expiring(within, nil)
+}
+
+// ScanExpiry will record the expiry status of all certificates and returns
+// the records that are expired or will expire within the given duration.
+func (m *Manager) ScanExpiry(within time.Duration) []storage.Record {
+	status := make(map[string]*ExpiryStatus)
+	records := m.expiring(within, status)
+	m.expiryLock.Lock()
+	m.expiry = status
+	m.expiryLock.Unlock()
+	return records
+}
+
+// expiring walks the records once for Expiring and, when status is not
+// nil, collects the expiry status of all certificates for ScanExpiry.
+func (m *Manager) expiring(within time.Duration, status map[string]*ExpiryStatus) []storage.Record {
+	now := time.Now()
+	renewed := make(map[string]bool)
+	candidates := make([]storage.Record, 0)
+	m.Each(func(record storage.Record) bool {
+		if predecessor := record.GetPredecessor(); predecessor != nil {
+			renewed[predecessor.String()] = true
+		}
+		if !record.HasCertificate() {
+			return true
+		}
+		expiry := GetExpiryStatus(record, now, within)
+		if status != nil {
+			status[record.GetId().String()] = &ExpiryStatus{
+				Status:   expiry,
+				NotAfter: record.GetCertificate().NotAfter,
+				Checked:  now,
+			}
+		}
+		if !record.IsRevoked() && expiry != EXPIRY_VALID {
+			candidates = append(candidates, record)
+		}
+		return true
+	})
+	records := make([]storage.Record, 0, len(candidates))
+	for _, record := range candidates {
+		if !renewed[record.GetId().String()] {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].GetCertificate().NotAfter.Before(records[j].GetCertificate().NotAfter)
+	})
+	return records
+}
+
+// GetExpiry returns the expiry status of the record at the
+// last scan or nil when the record was not scanned (yet).
+func (m *Manager) GetExpiry(record storage.Record) *ExpiryStatus {
+	m.expiryLock.RLock()
+	defer m.expiryLock.RUnlock()
+	if record.GetId() == nil {
+		return nil
+	}
+	return m.expiry[record.GetId().String()]
+}
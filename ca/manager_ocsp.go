@@ -0,0 +1,78 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"time"
+
+	"github.com/pbergman/caserver/storage"
+	"golang.org/x/crypto/ocsp"
+)
+
+// NewOCSPResponse will create a (rfc6960) OCSP response for the DER encoded
+// request. The response is signed by the CA that issued the certificate
+// and will be good, revoked or unknown when no certificate was found.
+func (m *Manager) NewOCSPResponse(raw []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(raw)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	issuer := m.findOCSPIssuer(req)
+	if issuer == nil {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	interval := m.config.CrlInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	now := time.Now().UTC()
+	tmpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(interval),
+	}
+	if record := m.SearchSerial(req.SerialNumber, issuer.GetCertificate()); record != nil {
+		if record.IsRevoked() {
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = record.GetRevocation().Time
+			tmpl.RevocationReason = record.GetRevocation().Reason
+		} else {
+			tmpl.Status = ocsp.Good
+		}
+	}
+	return ocsp.CreateResponse(issuer.GetCertificate(), issuer.GetCertificate(), tmpl, issuer.GetPrivateKey())
+}
+
+// findOCSPIssuer will return the CA that matches the issuer
+// name and key hash of the request or nil when not found.
+func (m *Manager) findOCSPIssuer(req *ocsp.Request) storage.Record {
+	if !req.HashAlgorithm.Available() {
+		return nil
+	}
+	for _, key := range m.storage.GetCa() {
+		record := m.Get(key)
+		if record == nil || !record.HasPrivateKey() {
+			continue
+		}
+		if m.matchOCSPIssuer(req, record.GetCertificate()) {
+			return record
+		}
+	}
+	return nil
+}
+
+func (m *Manager) matchOCSPIssuer(req *ocsp.Request, cert *x509.Certificate) bool {
+	raw, err := subjectPublicKey(cert.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return false
+	}
+	hasher := req.HashAlgorithm.New()
+	hasher.Write(raw)
+	if !bytes.Equal(hasher.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+	hasher.Reset()
+	hasher.Write(cert.RawSubject)
+	return bytes.Equal(hasher.Sum(nil), req.IssuerNameHash)
+}
@@ -0,0 +1,82 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+// Renew will create a new record with a certificate that has the same subject,
+// hosts and profile as the certificate of the given record, signed by the active issuer.
+// When rekey is false the existing key (or certificate request when the key is
+// unknown) will be used else a new key of the same type will be generated. The
+// given record is left untouched and the new record will reference it as its
+// predecessor. A revoked record can only be renewed with a new key because
+// the key could be revoked for being compromised.
+func (m *Manager) Renew(record storage.Record, rekey bool) (storage.Record, error) {
+	if record.IsCa() {
+		return nil, errors.New("a certificate authority can not be renewed")
+	}
+	if !record.HasCertificate() {
+		return nil, errors.New("record has no certificate to renew")
+	}
+	if record.IsRevoked() && !rekey {
+		return nil, errors.New("a revoked certificate can only be renewed with a new key")
+	}
+	issuer := m.Get(m.GetIssuer())
+	if issuer == nil {
+		return nil, errors.New("failed to find the issuer")
+	}
+	cert := record.GetCertificate()
+	renewal := m.storage.NewRecord()
+	switch {
+	case rekey:
+		options, err := NewKeyOptions(cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := options.Generate()
+		if err != nil {
+			return nil, err
+		}
+		renewal.SetPrivateKey(key)
+	case record.HasPrivateKey():
+		renewal.SetPrivateKey(record.GetPrivateKey())
+	case record.HasCertificateRequest():
+		// the subject of the certificate is used because it can differ
+		// from the request (like an acme request without common name)
+		csr := *record.GetCertificateRequest()
+		csr.Subject = cert.Subject
+		renewal.SetCertificateRequest(&csr)
+	default:
+		return nil, errors.New("record has no private key or certificate request to renew, a renewal with a new key is required")
+	}
+	if renewal.HasPrivateKey() {
+		hosts := make([]string, 0)
+		hosts = append(hosts, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+		csr, err := m.factory.NewCertificateRequest(renewal.GetPrivateKey(), cert.Subject, hosts, cert.EmailAddresses, cert.URIs)
+		if err != nil {
+			return nil, err
+		}
+		renewal.SetCertificateRequest(csr)
+	}
+	profile := m.GetProfile(record.GetProfile())
+	if profile == nil {
+		return nil, fmt.Errorf("failed to find the profile '%s' of the record", record.GetProfile())
+	}
+	renewed, err := m.NewCertificate(renewal.GetCertificateRequest(), issuer, profile, nil)
+	if err != nil {
+		return nil, err
+	}
+	renewal.SetCertificate(renewed)
+	renewal.SetProfile(record.GetProfile())
+	renewal.SetPredecessor(record.GetId())
+	if _, err := m.storage.Persist(renewal); err != nil {
+		return nil, err
+	}
+	return renewal, nil
+}
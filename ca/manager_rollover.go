@@ -0,0 +1,77 @@
+package ca
+
+import (
+	"errors"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+// Rollover will replace the active root with a new root (and intermediates)
+// and cross sign the new root with the old root so certificates issued by
+// the new root will be trusted by clients that only know the old root. The
+// old root is marked as retiring and will be available in GetRoots until it
+// expires, certificates issued before the rollover will still be valid.
+func (m *Manager) Rollover() (storage.Record, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old := m.Get(m.ca)
+	if old == nil || !old.HasPrivateKey() {
+		return nil, errors.New("failed to find the active CA")
+	}
+	root, err := m.createRoot()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := m.factory.NewCrossCertificate(root.GetCertificate(), old.GetCertificate(), old.GetPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	cross := m.storage.NewRecord()
+	cross.SetCertificate(cert)
+	// the old root is marked as retiring before the new root is persisted
+	// so a failure halfway will not leave two active roots behind, when
+	// no active root is found Init will create a new one.
+	old.SetRetiring(true)
+	if _, err := m.storage.Persist(old); err != nil {
+		return nil, err
+	}
+	intermediates, err := m.persistRollover(root, cross)
+	if err != nil {
+		old.SetRetiring(false)
+		m.storage.Persist(old)
+		m.ca = old.GetId()
+		return nil, err
+	}
+	// the state is only changed when everything is persisted
+	m.retiring = append(m.retiring, old.GetId())
+	m.ca = root.GetId()
+	m.intermediates = intermediates
+	return root, nil
+}
+
+// persistRollover will persist the new root, the cross certificate and create the
+// intermediates for the new root. The records that were persisted are removed
+// again on error so a failed rollover can be retried.
+func (m *Manager) persistRollover(root, cross storage.Record) (intermediates []*storage.StorageKey, err error) {
+	persisted := make([]*storage.StorageKey, 0)
+	defer func() {
+		if err != nil {
+			for _, key := range append(persisted, intermediates...) {
+				m.storage.Remove(key)
+			}
+		}
+	}()
+	for _, record := range []storage.Record{root, cross} {
+		if _, err = m.storage.Persist(record); err != nil {
+			return nil, err
+		}
+		persisted = append(persisted, record.GetId())
+	}
+	if intermediates, err = m.initIntermediates(root, nil); err != nil {
+		return intermediates, err
+	}
+	if err = m.updateCRLs(); err != nil {
+		return intermediates, err
+	}
+	return intermediates, nil
+}
@@ -0,0 +1,253 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pbergman/caserver/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// the types of ssh certificates that can be issued
+const (
+	SSH_CERT_USER string = "user"
+	SSH_CERT_HOST string = "host"
+)
+
+// the critical options (see PROTOCOL.certkeys of openssh) that are supported
+// for user certificates, host certificates have no critical options.
+const (
+	SSH_OPTION_FORCE_COMMAND  string = "force-command"
+	SSH_OPTION_SOURCE_ADDRESS string = "source-address"
+)
+
+// the extensions of user certificates when none are requested, these are
+// the same as the default permissions of ssh-keygen.
+var sshDefaultExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// SshOptions holds the request for a ssh certificate
+type SshOptions struct {
+	// the type of the certificate (SSH_CERT_USER or SSH_CERT_HOST)
+	Type string
+	// the identifier that is logged by the server, defaults
+	// to the first principal when empty.
+	KeyId string
+	// the user or host names the certificate is valid for
+	Principals []string
+	// the requested validity, the user_ttl or host_ttl
+	// of the ssh config is used when nil.
+	Validity        *Validity
+	CriticalOptions map[string]string
+	// the extensions of a user certificate, the default
+	// permissions are used when nil.
+	Extensions []string
+}
+
+// GetSshCa returns the record with the key of the ssh CA, a new key
+// (of the ssh key config) is created when the storage has none yet. The
+// record is kept so the storage is only searched on first use.
+func (m *Manager) GetSshCa() (storage.Record, error) {
+	if !m.config.Ssh.Enabled {
+		return nil, errors.New("the ssh certificate authority is disabled")
+	}
+	m.sshLock.Lock()
+	defer m.sshLock.Unlock()
+	if m.sshCa != nil {
+		return m.sshCa, nil
+	}
+	for _, key := range m.storage.GetSshCa() {
+		if record := m.Get(key); record != nil && record.HasPrivateKey() {
+			m.sshCa = record
+			return record, nil
+		}
+	}
+	conf := m.config.Ssh.Key
+	key, err := (KeyOptions{Type: conf.Type, Bits: conf.Bits, Curve: conf.Curve}).Generate()
+	if err != nil {
+		return nil, err
+	}
+	record := m.storage.NewRecord()
+	record.SetPrivateKey(key)
+	record.SetSshCa(true)
+	if _, err := m.storage.Persist(record); err != nil {
+		return nil, err
+	}
+	m.sshCa = record
+	return record, nil
+}
+
+// GetSshCaPublicKey returns the public key of the ssh CA which can be used
+// in the TrustedUserCAKeys file of sshd or as @cert-authority in known_hosts.
+func (m *Manager) GetSshCaPublicKey() (ssh.PublicKey, error) {
+	record, err := m.GetSshCa()
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewPublicKey(record.GetPrivateKey().Public())
+}
+
+// SignSshKey will sign the public key into a user or host certificate with
+// the ssh CA and returns the (persisted) record of the certificate.
+func (m *Manager) SignSshKey(key ssh.PublicKey, options SshOptions) (storage.Record, error) {
+	cert, err := m.newSshCertificate(key, options)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := m.GetSshCa()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromSigner(authority.GetPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+	record := m.storage.NewRecord()
+	record.SetSshCertificate(cert)
+	if _, err := m.storage.Persist(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// newSshCertificate will validate the options and create the (unsigned) certificate
+func (m *Manager) newSshCertificate(key ssh.PublicKey, options SshOptions) (*ssh.Certificate, error) {
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, errors.New("expected a public key, not a certificate")
+	}
+	public, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type '%s'", key.Type())
+	}
+	if len(options.Principals) == 0 {
+		return nil, errors.New("at least one principal is required")
+	}
+	cert := &ssh.Certificate{
+		Key:             key,
+		KeyId:           options.KeyId,
+		ValidPrincipals: options.Principals,
+		Permissions: ssh.Permissions{
+			CriticalOptions: make(map[string]string),
+			Extensions:      make(map[string]string),
+		},
+	}
+	if cert.KeyId == "" {
+		cert.KeyId = options.Principals[0]
+	}
+	var hosts []string
+	ttl := m.config.Ssh.UserTtl
+	switch options.Type {
+	case SSH_CERT_USER:
+		cert.CertType = ssh.UserCert
+		if err := m.checkSshPrincipals(options.Principals); err != nil {
+			return nil, err
+		}
+		if err := setSshCriticalOptions(cert, options.CriticalOptions); err != nil {
+			return nil, err
+		}
+		extensions := options.Extensions
+		if extensions == nil {
+			extensions = sshDefaultExtensions
+		}
+		for _, name := range extensions {
+			cert.Permissions.Extensions[name] = ""
+		}
+	case SSH_CERT_HOST:
+		cert.CertType = ssh.HostCert
+		if len(options.CriticalOptions) > 0 || len(options.Extensions) > 0 {
+			return nil, errors.New("a host certificate can not have critical options or extensions")
+		}
+		hosts, ttl = options.Principals, m.config.Ssh.HostTtl
+	default:
+		return nil, fmt.Errorf("invalid certificate type '%s', expected %s or %s", options.Type, SSH_CERT_USER, SSH_CERT_HOST)
+	}
+	if err := m.GetPolicy().CheckSshRequest(public.CryptoPublicKey(), hosts); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notBefore, notAfter := now, now.Add(ttl)
+	if options.Validity != nil {
+		end, err := options.Validity.getNotAfter(now)
+		if err != nil {
+			return nil, err
+		}
+		if !options.Validity.NotBefore.IsZero() {
+			notBefore = options.Validity.NotBefore
+		}
+		if !end.IsZero() {
+			notAfter = end
+		} else {
+			notAfter = notBefore.Add(ttl)
+		}
+	}
+	if max := now.Add(m.config.Ssh.MaxTtl); notAfter.After(max) {
+		return nil, fmt.Errorf("the validity exceeds the max of %s", m.config.Ssh.MaxTtl)
+	}
+	if !notAfter.After(notBefore) {
+		return nil, errors.New("the end of the validity should be after the start")
+	}
+	// backdated a minute for a clock skew between the server and hosts
+	cert.ValidAfter = uint64(notBefore.Add(-time.Minute).Unix())
+	cert.ValidBefore = uint64(notAfter.Unix())
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	cert.Serial = binary.BigEndian.Uint64(serial)
+	return cert, nil
+}
+
+// checkSshPrincipals checks if the principals of a user certificate are in
+// the allowed_principals of the ssh config, so users like root can only be
+// requested when configured.
+func (m *Manager) checkSshPrincipals(principals []string) error {
+	violations := new(PolicyError)
+	allowed := m.config.Ssh.AllowedPrincipals
+	for _, principal := range principals {
+		var found bool
+		for _, name := range allowed {
+			if name == principal {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations.add("allowed_principals", "(%s) does not allow the principal '%s'", strings.Join(allowed, ", "), principal)
+		}
+	}
+	return violations.get()
+}
+
+func setSshCriticalOptions(cert *ssh.Certificate, options map[string]string) error {
+	for name, value := range options {
+		switch name {
+		case SSH_OPTION_FORCE_COMMAND:
+			if value == "" {
+				return fmt.Errorf("the critical option '%s' can not be empty", name)
+			}
+		case SSH_OPTION_SOURCE_ADDRESS:
+			for _, address := range strings.Split(value, ",") {
+				if _, _, err := net.ParseCIDR(address); err != nil && net.ParseIP(address) == nil {
+					return fmt.Errorf("invalid address '%s' for the critical option '%s'", address, name)
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported critical option '%s'", name)
+		}
+		cert.Permissions.CriticalOptions[name] = value
+	}
+	return nil
+}
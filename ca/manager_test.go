@@ -0,0 +1,1011 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestManager(t *testing.T, authority *config.CaConfig) *Manager {
+	key := new([32]byte)
+	rand.Read(key[:])
+	conf := &config.Config{Authorities: []*config.CaConfig{authority}}
+	conf.CaNotAfter = [3]int{1, 0, 0}
+	conf.PemNotAfter = [3]int{1, 0, 0}
+	if authority.Name == "" {
+		authority.Name = config.DEFAULT_CA
+	}
+	if authority.Subject == nil {
+		authority.Subject = &pkix.Name{CommonName: "example CA"}
+	}
+	if authority.Key.Type == "" {
+		authority.Key = config.KeyConfig{Type: KEY_TYPE_ECDSA}
+	}
+	manager, err := NewManager(conf, authority, storage.NewDiskStorage(t.TempDir(), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func TestManager_Intermediates(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 2})
+
+	if c := len(manager.intermediates); c != 2 {
+		t.Fatalf("expected 2 intermediates got %d", c)
+	}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := manager.GetChain(record)
+
+	if c := len(chain); c != 2 {
+		t.Fatalf("expected a chain of 2 intermediates got %d", c)
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(manager.Get(manager.GetCa()).GetCertificate())
+
+	for _, ca := range chain {
+		intermediates.AddCert(ca.GetCertificate())
+	}
+
+	if _, err := record.GetCertificate().Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new manager on the same storage should reuse the hierarchy
+	other, err := NewManager(manager.config, manager.authority, manager.storage)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *other.GetIssuer() != *manager.GetIssuer() {
+		t.Fatalf("expected issuer %s got %s", manager.GetIssuer(), other.GetIssuer())
+	}
+}
+
+func TestManager_Rollover(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	old := manager.Get(manager.GetCa())
+
+	if _, err := manager.Rollover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if roots := manager.GetRoots(); len(roots) != 2 || !roots[1].IsRetiring() {
+		t.Fatalf("expected the active and retiring root got %d roots", len(roots))
+	}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, ca := range manager.GetChain(record) {
+		intermediates.AddCert(ca.GetCertificate())
+	}
+
+	// should be trusted by both the old and the new root
+	for _, root := range []*x509.Certificate{old.GetCertificate(), manager.Get(manager.GetCa()).GetCertificate()} {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		if _, err := record.GetCertificate().Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots, Intermediates: intermediates}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the retiring state should survive a restart
+	other, err := NewManager(manager.config, manager.authority, manager.storage)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *other.GetCa() != *manager.GetCa() || len(other.GetRoots()) != 2 {
+		t.Fatal("expected the new root to be active after restart")
+	}
+
+	// an interrupted rollover could leave an other active root behind
+	newest, err := manager.createRoot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := *newest.GetCertificate()
+	template.NotBefore = template.NotBefore.Add(time.Hour)
+	raw, err := x509.CreateCertificate(rand.Reader, &template, &template, newest.GetPrivateKey().Public(), newest.GetPrivateKey())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(raw)
+	newest.SetCertificate(cert)
+
+	if _, err := manager.storage.Persist(newest); err != nil {
+		t.Fatal(err)
+	}
+
+	if other, err = NewManager(manager.config, manager.authority, manager.storage); err != nil {
+		t.Fatal(err)
+	}
+
+	if roots := other.GetRoots(); *other.GetCa() != *newest.GetId() || len(roots) != 3 || !roots[1].IsRetiring() || !roots[2].IsRetiring() {
+		t.Fatal("expected the newest root to be active and the other to be retiring")
+	}
+}
+
+// failingStorage will fail the persist call with the given number
+type failingStorage struct {
+	storage.Storage
+	calls, fail int
+}
+
+func (f *failingStorage) Persist(record storage.Record) (*storage.StorageKey, error) {
+	if f.calls++; f.calls == f.fail {
+		return nil, errors.New("failed to persist")
+	}
+	return f.Storage.Persist(record)
+}
+
+func TestManager_RolloverFailure(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	db, root, issuer := manager.storage, manager.Get(manager.GetCa()).GetCertificate(), *manager.GetIssuer()
+
+	// the old root, new root and cross certificate are persisted before the intermediate
+	for _, fail := range []int{2, 3, 4} {
+		manager.storage = &failingStorage{Storage: db, fail: fail}
+
+		if _, err := manager.Rollover(); err == nil {
+			t.Fatalf("expected persist %d to fail the rollover", fail)
+		}
+
+		if active := manager.Get(manager.GetCa()); active == nil || !active.GetCertificate().Equal(root) || *manager.GetIssuer() != issuer || len(manager.retiring) != 0 {
+			t.Fatal("expected the state to be unchanged after a failed rollover")
+		}
+
+		if roots := manager.GetRoots(); len(roots) != 1 || roots[0].IsRetiring() {
+			t.Fatalf("expected only the active root got %d roots", len(roots))
+		}
+
+		if list := db.GetCa(); len(list) != 2 {
+			t.Fatalf("expected the records of the failed rollover to be removed got %d CA records", len(list))
+		}
+	}
+
+	manager.storage = db
+
+	if _, err := manager.Rollover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if roots := manager.GetRoots(); len(roots) != 2 || len(manager.retiring) != 1 {
+		t.Fatalf("expected the active and retiring root got %d roots", len(roots))
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	issuer := manager.Get(manager.GetIssuer())
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Revoke(record, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Revoke(record, 1); err == nil {
+		t.Fatal("expected an error revoking a revoked certificate")
+	}
+
+	raw, err := manager.GetCRL("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(raw)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := crl.CheckSignatureFrom(issuer.GetCertificate()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := len(crl.RevokedCertificateEntries); c != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(record.GetCertificate().SerialNumber) != 0 || crl.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Fatalf("expected the revoked certificate in the CRL got %d entries", c)
+	}
+
+	if revoked := manager.Lookup(record.GetId().String()); revoked == nil || !revoked.IsRevoked() {
+		t.Fatal("expected the revocation to be persisted")
+	}
+}
+
+func TestManager_OCSP(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	issuer := manager.Get(manager.GetIssuer())
+
+	status := func(cert *x509.Certificate) int {
+		raw, err := ocsp.CreateRequest(cert, issuer.GetCertificate(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err = manager.NewOCSPResponse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ocsp.ParseResponseForCert(raw, cert, issuer.GetCertificate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := status(record.GetCertificate()); s != ocsp.Good {
+		t.Fatalf("expected status good got %d", s)
+	}
+
+	if err := manager.Revoke(record, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := status(record.GetCertificate()); s != ocsp.Revoked {
+		t.Fatalf("expected status revoked got %d", s)
+	}
+
+	unknown := *record.GetCertificate()
+	unknown.SerialNumber = big.NewInt(1)
+
+	if s := status(&unknown); s != ocsp.Unknown {
+		t.Fatalf("expected status unknown got %d", s)
+	}
+
+	// the urls are only added when the public url is configured
+	if cert := record.GetCertificate(); len(cert.OCSPServer) != 0 || len(cert.CRLDistributionPoints) != 0 || len(cert.IssuingCertificateURL) != 0 {
+		t.Fatal("expected no ocsp, crl or issuer urls without a configured url")
+	}
+
+	manager.config.Url = "http://ca.example.com"
+	cert, err := manager.NewCertificate(record.GetCertificateRequest(), issuer, nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cert.OCSPServer) != 1 || cert.OCSPServer[0] != "http://ca.example.com/api/v1/ca/ocsp" || len(cert.CRLDistributionPoints) != 1 || len(cert.IssuingCertificateURL) != 1 {
+		t.Fatalf("expected the ocsp, crl and issuer urls got %v", cert.OCSPServer)
+	}
+}
+
+func TestManager_Renew(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+
+	record, err := manager.NewCertificateRequest([]string{"example.com", "127.0.0.1"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA, Curve: "P-384"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rekey := range []bool{false, true} {
+		renewal, err := manager.Renew(record, rekey)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key := renewal.GetPredecessor(); key == nil || key.String() != record.GetId().String() {
+			t.Fatalf("expected predecessor %s got %v", record.GetId(), key)
+		}
+
+		old, cert := record.GetCertificate(), renewal.GetCertificate()
+
+		if cert.SerialNumber.Cmp(old.SerialNumber) == 0 || cert.Subject.String() != old.Subject.String() || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 {
+			t.Fatalf("expected a new certificate for the same subject and hosts")
+		}
+
+		if same := bytes.Equal(cert.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo); same == rekey {
+			t.Fatalf("expected rekey %v to change the key: %v", rekey, !same)
+		}
+
+		if options, _ := NewKeyOptions(cert.PublicKey); options.Curve != "P-384" {
+			t.Fatalf("expected a P-384 key got %s", options.Curve)
+		}
+	}
+}
+
+func TestManager_RenewRevoked(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Revoke(record, ocsp.KeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.Renew(record, false); err == nil {
+		t.Fatal("expected an error for renewing a revoked certificate with the same key")
+	}
+
+	renewal, err := manager.Renew(record, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(renewal.GetCertificate().RawSubjectPublicKeyInfo, record.GetCertificate().RawSubjectPublicKeyInfo) {
+		t.Fatal("expected the renewal of a revoked certificate to have a new key")
+	}
+}
+
+func TestManager_RenewRequest(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+	key, err := KeyOptions{Type: KEY_TYPE_ECDSA}.Generate()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a request without common name like the acme clients send
+	raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"example.com"}}, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(raw)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr.Subject.CommonName = "example.com"
+	cert, err := manager.NewCertificate(csr, manager.Get(manager.GetIssuer()), nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := manager.NewRecord()
+	record.SetCertificateRequest(csr)
+	record.SetCertificate(cert)
+
+	if _, err := manager.Save(record); err != nil {
+		t.Fatal(err)
+	}
+
+	renewal, err := manager.Renew(manager.Get(record.GetId()), false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewed := renewal.GetCertificate(); renewed.Subject.CommonName != "example.com" || !bytes.Equal(renewed.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		t.Fatal("expected a renewal with the same subject and key")
+	}
+}
+
+func TestManager_RevokeRenewed(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	renewal, err := manager.Renew(record, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := record.GetId().String()
+
+	if err := manager.Revoke(record, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.GetId().String() != id {
+		t.Fatalf("expected the id %s to be kept after revoking got %s", id, record.GetId())
+	}
+
+	if found := manager.Get(renewal.GetPredecessor()); found == nil || !found.IsRevoked() {
+		t.Fatal("expected the predecessor of the renewal to be the revoked record")
+	}
+
+	if conflicts := manager.Conflicts([]string{"example.com"}, nil, nil, nil); len(conflicts) != 1 || conflicts[0].GetId().String() != renewal.GetId().String() {
+		t.Fatalf("expected only the renewal as conflict got %d", len(conflicts))
+	}
+
+	if err := manager.Revoke(renewal, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts := manager.Conflicts([]string{"example.com"}, nil, nil, nil); len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts after revoking the renewal got %d", len(conflicts))
+	}
+}
+
+func TestManager_Profile(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	profile := manager.GetProfile("client")
+	profile.MaxValidity = [3]int{0, 0, 7}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), manager.GetProfile("smime"), nil); err == nil {
+		t.Fatal("expected an error for dns names with the smime profile")
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), profile, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := record.GetCertificate()
+
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature || len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatalf("expected a client certificate got key usage %d and ext key usage %v", cert.KeyUsage, cert.ExtKeyUsage)
+	}
+
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		t.Fatal("expected basic constraints for a non CA certificate")
+	}
+
+	if cert.NotAfter.After(time.Now().AddDate(0, 0, 7)) {
+		t.Fatalf("expected the validity to be limited to 7 days got %s", cert.NotAfter)
+	}
+
+	if record.GetProfile() != "client" {
+		t.Fatalf("expected the profile to be stored got '%s'", record.GetProfile())
+	}
+
+	renewal, err := manager.Renew(record, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewal.GetProfile() != "client" || renewal.GetCertificate().ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatal("expected the renewal to use the profile of the predecessor")
+	}
+}
+
+func TestManager_Validity(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	issuer := manager.Get(manager.GetIssuer())
+	now := time.Now()
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr := record.GetCertificateRequest()
+
+	for _, c := range []struct {
+		validity *Validity
+		expected time.Time
+	}{
+		{&Validity{TTL: 2 * time.Hour}, now.Add(2 * time.Hour)},
+		{&Validity{NotBefore: now.Add(time.Hour), TTL: time.Hour}, now.Add(2 * time.Hour)},
+		{&Validity{NotAfter: now.AddDate(0, 0, 200)}, now.AddDate(0, 0, 200)},
+		{&Validity{NotAfter: now.AddDate(5, 0, 0)}, now.AddDate(1, 0, 0)},
+		{nil, now.AddDate(1, 0, 0)},
+	} {
+		cert, err := manager.NewCertificate(csr, issuer, nil, c.validity)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cert.NotAfter.Sub(c.expected); diff > time.Second || diff < -time.Second {
+			t.Fatalf("expected not after %s got %s", c.expected, cert.NotAfter)
+		}
+	}
+
+	if _, err := manager.NewCertificate(csr, issuer, nil, &Validity{NotAfter: now.Add(time.Hour), TTL: time.Hour}); err == nil {
+		t.Fatal("expected an error combining not after and ttl")
+	}
+
+	if _, err := manager.NewCertificate(csr, issuer, nil, &Validity{NotBefore: now.AddDate(2, 0, 0), TTL: time.Hour}); err == nil {
+		t.Fatal("expected an error for a not before after the max validity")
+	} else if _, ok := err.(*RequestError); !ok {
+		t.Fatalf("expected a request error got %T", err)
+	}
+
+	if _, err := manager.NewCertificate(csr, issuer, nil, &Validity{NotBefore: now.AddDate(-1, 0, 0), TTL: time.Hour}); err == nil {
+		t.Fatal("expected an error for a backdated not before")
+	}
+
+	// the validity can not exceed the validity of the issuer
+	manager.config.PemNotAfter = [3]int{5, 0, 0}
+
+	if cert, err := manager.NewCertificate(csr, issuer, nil, &Validity{NotAfter: now.AddDate(3, 0, 0)}); err != nil || !cert.NotAfter.Equal(issuer.GetCertificate().NotAfter) {
+		t.Fatalf("expected the not after to be capped by the issuer: %v", err)
+	}
+}
+
+// serialFactory will return the same certificate for every request
+type serialFactory struct {
+	FactoryInterface
+	cert *x509.Certificate
+}
+
+func (s serialFactory) NewCertificate(*x509.CertificateRequest, *x509.Certificate, crypto.Signer, *CertificateOptions) (*x509.Certificate, error) {
+	return s.cert, nil
+}
+
+func TestManager_Serial(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	issuer := manager.Get(manager.GetIssuer())
+	serials := make(map[string]bool)
+
+	for i := 0; i < 10; i++ {
+		record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		serial := record.GetCertificate().SerialNumber
+
+		if serial.BitLen() < 64 || serials[serial.String()] {
+			t.Fatalf("expected an unique serial of at least 64 bits got %x", serial)
+		}
+
+		serials[serial.String()] = true
+
+		if found := manager.SearchSerial(serial, issuer.GetCertificate()); found == nil || found.GetId().String() != record.GetId().String() {
+			t.Fatalf("expected to find record %s by serial %x", record.GetId(), serial)
+		}
+	}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is updated when the record is persisted again
+	if err := manager.Revoke(record, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := manager.SearchSerial(record.GetCertificate().SerialNumber, issuer.GetCertificate()); found == nil || !found.IsRevoked() {
+		t.Fatal("expected to find the revoked record by serial")
+	}
+
+	factory := manager.factory
+	manager.factory = serialFactory{factory, record.GetCertificate()}
+
+	if _, err := manager.NewCertificate(record.GetCertificateRequest(), issuer, nil, nil); err == nil {
+		t.Fatal("expected an error for a duplicate serial")
+	}
+
+	manager.factory = factory
+
+	if err := manager.Remove(record.GetId()); err != nil {
+		t.Fatal(err)
+	}
+
+	if manager.SearchSerial(record.GetCertificate().SerialNumber, issuer.GetCertificate()) != nil {
+		t.Fatal("expected the removed record to be removed from the index")
+	}
+}
+
+func TestManager_NameConstraints(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	manager := newTestManager(t, &config.CaConfig{
+		Intermediates: 1,
+		NameConstraints: config.NameConstraints{
+			PermittedDNS: []string{".dev.example.com", "test"},
+			PermittedIPs: []*net.IPNet{network},
+		},
+	})
+	root, issuer := manager.Get(manager.GetCa()), manager.Get(manager.GetIssuer())
+
+	for _, cert := range []*x509.Certificate{root.GetCertificate(), issuer.GetCertificate()} {
+		if !cert.PermittedDNSDomainsCritical || len(cert.PermittedDNSDomains) != 2 || len(cert.PermittedIPRanges) != 1 {
+			t.Fatalf("expected the name constraints in the CA certificate '%s'", cert.Subject.CommonName)
+		}
+	}
+
+	for hosts, allowed := range map[string]bool{
+		"api.dev.example.com": true,
+		"foo.test":            true,
+		"10.1.2.3":            true,
+		"dev.example.com":     false,
+		"example.com":         false,
+		"192.168.1.1":         false,
+	} {
+		dns, ips := SplitHosts([]string{hosts})
+		err := manager.CheckNameConstraints(issuer, dns, ips, nil)
+
+		if _, ok := err.(*PolicyError); allowed == ok {
+			t.Fatalf("expected %s to be allowed %v got %v", hosts, allowed, err)
+		}
+	}
+
+	record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err == nil {
+		t.Fatal("expected an error signing a name outside the name constraints")
+	}
+
+	record, err = manager.NewCertificateRequest([]string{"api.dev.example.com"}, nil, nil, pkix.Name{CommonName: "api"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root.GetCertificate())
+	intermediates.AddCert(issuer.GetCertificate())
+
+	if _, err := record.GetCertificate().Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "api.dev.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager_VerifyClientCertificate(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	issuer := manager.Get(manager.GetIssuer())
+
+	record, err := manager.NewCertificateRequest(nil, nil, nil, pkix.Name{CommonName: "device"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, manager.GetProfile("client"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := manager.VerifyClientCertificate(record.GetCertificate())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.GetId().String() != record.GetId().String() {
+		t.Fatal("expected the record of the client certificate")
+	}
+
+	other := newTestManager(t, &config.CaConfig{Intermediates: 1})
+
+	if _, err := other.VerifyClientCertificate(record.GetCertificate()); err == nil {
+		t.Fatal("expected an error for a certificate of another CA")
+	}
+
+	if err := manager.Revoke(record, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.VerifyClientCertificate(record.GetCertificate()); err == nil {
+		t.Fatal("expected an error for a revoked certificate")
+	}
+}
+
+func TestManager_Ssh(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{Intermediates: 1})
+	manager.config.Ssh = config.SshConfig{Enabled: true, AllowedPrincipals: []string{"alice"}, Key: config.KeyConfig{Type: KEY_TYPE_ED25519}, UserTtl: time.Hour, HostTtl: 24 * time.Hour, MaxTtl: 48 * time.Hour}
+	manager.config.Policies = []*config.PolicyConfig{{DeniedDomains: []string{"*.prod.example.com"}, MaxEcdsaBits: 521}}
+
+	authority, err := manager.GetSshCaPublicKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := manager.GetSshCaPublicKey(); again == nil || !bytes.Equal(again.Marshal(), authority.Marshal()) {
+		t.Fatal("expected the ssh CA key to be reused")
+	}
+
+	other, err := NewManager(manager.config, manager.authority, manager.storage)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := other.GetSshCaPublicKey(); again == nil || !bytes.Equal(again.Marshal(), authority.Marshal()) {
+		t.Fatal("expected the persisted ssh CA key to be reused")
+	}
+
+	key, _ := (KeyOptions{Type: KEY_TYPE_ECDSA}).Generate()
+	public, _ := ssh.NewPublicKey(key.Public())
+
+	record, err := manager.SignSshKey(public, SshOptions{Type: SSH_CERT_USER, Principals: []string{"alice"}, CriticalOptions: map[string]string{SSH_OPTION_SOURCE_ADDRESS: "10.0.0.0/8"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := manager.Get(record.GetId()).GetSshCertificate()
+
+	if cert == nil || !bytes.Equal(cert.Marshal(), record.GetSshCertificate().Marshal()) {
+		t.Fatal("expected the ssh certificate to be persisted")
+	}
+
+	checker := &ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return bytes.Equal(auth.Marshal(), authority.Marshal())
+	}}
+
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.KeyId != "alice" || len(cert.Permissions.Extensions) != len(sshDefaultExtensions) || cert.Permissions.CriticalOptions[SSH_OPTION_SOURCE_ADDRESS] != "10.0.0.0/8" {
+		t.Fatal("expected the key id, default extensions and source address of the certificate")
+	}
+
+	if _, err := manager.SignSshKey(public, SshOptions{Type: SSH_CERT_HOST, Principals: []string{"vm.prod.example.com"}}); err == nil {
+		t.Fatal("expected a policy violation for the host principal")
+	}
+
+	if _, err := manager.SignSshKey(public, SshOptions{Type: SSH_CERT_USER, Principals: []string{"alice"}, Validity: &Validity{TTL: 72 * time.Hour}}); err == nil {
+		t.Fatal("expected an error for a validity that exceeds the max ttl")
+	}
+
+	if _, err := manager.SignSshKey(public, SshOptions{Type: SSH_CERT_USER}); err == nil {
+		t.Fatal("expected an error without principals")
+	}
+
+	if _, err := manager.SignSshKey(public, SshOptions{Type: SSH_CERT_USER, Principals: []string{"alice", "root"}}); err == nil {
+		t.Fatal("expected a policy violation for a principal that is not allowed")
+	}
+
+	if _, err := manager.SignSshKey(cert, SshOptions{Type: SSH_CERT_USER, Principals: []string{"alice"}}); err == nil {
+		t.Fatal("expected an error for signing a certificate")
+	}
+}
+
+func TestManager_AltNames(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+	uri, _ := url.Parse("spiffe://example.com/service")
+
+	record, err := manager.NewCertificateRequest(nil, []string{"service@example.com"}, []*url.URL{uri}, pkix.Name{CommonName: "service"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, manager.Get(manager.GetIssuer()), manager.GetProfile("client"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if cert := record.GetCertificate(); len(cert.DNSNames) != 0 || len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "service@example.com" || len(cert.URIs) != 1 || cert.URIs[0].String() != uri.String() {
+		t.Fatalf("expected the email and uri alternative names got %v %v %v", cert.DNSNames, cert.EmailAddresses, cert.URIs)
+	}
+
+	renewal, err := manager.Renew(record, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert := renewal.GetCertificate(); len(cert.EmailAddresses) != 1 || len(cert.URIs) != 1 {
+		t.Fatal("expected the renewal to keep the email and uri alternative names")
+	}
+}
+
+func TestManager_CopyExtensions(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+	key, _ := KeyOptions{Type: KEY_TYPE_ECDSA}.Generate()
+	extensions := []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{0x05, 0x00}},
+		{Id: asn1.ObjectIdentifier{1, 2, 3, 5}, Value: []byte{0x05, 0x00}},
+	}
+	raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "example"}, DNSNames: []string{"example.com"}, ExtraExtensions: extensions}, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, _ := x509.ParseCertificateRequest(raw)
+
+	hasExtension := func(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+		for _, extension := range cert.Extensions {
+			if extension.Id.Equal(oid) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cert, err := manager.NewCertificate(csr, manager.Get(manager.GetIssuer()), nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hasExtension(cert, extensions[0].Id) || hasExtension(cert, extensions[1].Id) {
+		t.Fatal("expected no requested extensions to be copied by default")
+	}
+
+	policy := &config.PolicyConfig{CopyExtensions: []asn1.ObjectIdentifier{extensions[0].Id}}
+	util.SetDefaults(policy)
+	manager.config.Policies = append(manager.config.Policies, policy)
+
+	if cert, err = manager.NewCertificate(csr, manager.Get(manager.GetIssuer()), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasExtension(cert, extensions[0].Id) || hasExtension(cert, extensions[1].Id) {
+		t.Fatal("expected only the extension allowed by the policy to be copied")
+	}
+}
+
+func TestManager_Conflicts(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+	issuer := manager.Get(manager.GetIssuer())
+
+	record, err := manager.NewCertificateRequest([]string{"*.example.com", "10.0.0.1"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.SignCertificateRequest(record, issuer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for hosts, size := range map[string]int{"api.example.com": 1, "*.example.com": 1, "10.0.0.1": 1, "example.com": 0, "a.b.example.com": 0, "10.0.0.2": 0} {
+		dns, ips := SplitHosts([]string{hosts})
+		if conflicts := manager.Conflicts(dns, ips, nil, nil); len(conflicts) != size {
+			t.Fatalf("expected %d conflicts for %s got %d", size, hosts, len(conflicts))
+		}
+	}
+
+	renewal, err := manager.Renew(record, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts := manager.Conflicts([]string{"api.example.com"}, nil, nil, nil); len(conflicts) != 1 || !bytes.Equal(conflicts[0].GetId().Bytes(), renewal.GetId().Bytes()) {
+		t.Fatal("expected only the renewal to conflict")
+	}
+
+	if err := manager.Revoke(renewal, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts := manager.Conflicts([]string{"api.example.com"}, nil, nil, nil); len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts for a revoked certificate got %d", len(conflicts))
+	}
+}
+
+func TestManager_Expiry(t *testing.T) {
+	manager := newTestManager(t, &config.CaConfig{})
+	issuer := manager.Get(manager.GetIssuer())
+	records := make([]storage.Record, 2)
+
+	for i, validity := range []*Validity{{TTL: 48 * time.Hour}, nil} {
+		record, err := manager.NewCertificateRequest([]string{"example.com"}, nil, nil, pkix.Name{CommonName: "example"}, KeyOptions{Type: KEY_TYPE_ECDSA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.SignCertificateRequest(record, issuer, nil, validity); err != nil {
+			t.Fatal(err)
+		}
+		records[i] = record
+	}
+
+	within := 30 * 24 * time.Hour
+
+	if expiring := manager.ScanExpiry(within); len(expiring) != 1 || !bytes.Equal(expiring[0].GetId().Bytes(), records[0].GetId().Bytes()) {
+		t.Fatalf("expected only the first certificate to expire got %d", len(expiring))
+	}
+
+	for record, status := range map[storage.Record]string{records[0]: EXPIRY_EXPIRING, records[1]: EXPIRY_VALID, issuer: EXPIRY_VALID} {
+		if expiry := manager.GetExpiry(record); expiry == nil || expiry.Status != status || !expiry.NotAfter.Equal(record.GetCertificate().NotAfter) {
+			t.Fatalf("expected the status %s for '%s' got %v", status, record.GetCertificate().Subject.CommonName, expiry)
+		}
+	}
+
+	if expiring := manager.Expiring(2 * 365 * 24 * time.Hour); len(expiring) != 3 || !expiring[0].GetCertificate().NotAfter.Before(expiring[2].GetCertificate().NotAfter) {
+		t.Fatalf("expected the certificates and CA ordered by expiry got %d", len(expiring))
+	}
+
+	if _, err := manager.Renew(records[0], false); err != nil {
+		t.Fatal(err)
+	}
+
+	if expiring := manager.Expiring(within); len(expiring) != 0 {
+		t.Fatalf("expected no expiring certificates after the renewal got %d", len(expiring))
+	}
+
+	if status := GetExpiryStatus(records[0], time.Now().Add(72*time.Hour), 0); status != EXPIRY_EXPIRED {
+		t.Fatalf("expected the status %s got %s", EXPIRY_EXPIRED, status)
+	}
+}
@@ -0,0 +1,39 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/pbergman/caserver/storage"
+)
+
+// VerifyClientCertificate will check if the (tls client) certificate is issued by this
+// CA for client authentication and is not revoked, it returns the record of the
+// certificate so it can be used to identify the client.
+func (m *Manager) VerifyClientCertificate(cert *x509.Certificate) (storage.Record, error) {
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, root := range m.GetRoots() {
+		opts.Roots.AddCert(root.GetCertificate())
+	}
+	for _, key := range m.storage.GetCa() {
+		if record := m.Get(key); record != nil && record.HasCertificate() && !isSelfSigned(record.GetCertificate()) {
+			opts.Intermediates.AddCert(record.GetCertificate())
+		}
+	}
+	chains, err := cert.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+	record := m.SearchSerial(cert.SerialNumber, chains[0][1])
+	if record == nil {
+		return nil, errors.New("the certificate is not issued by this CA")
+	}
+	if record.IsRevoked() {
+		return nil, errors.New("the certificate is revoked")
+	}
+	return record, nil
+}
@@ -0,0 +1,305 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/util"
+)
+
+// hostPattern matches a common name that looks like a (wildcard) dns name
+var hostPattern = regexp.MustCompile(`^(?i)(\*\.)?[a-z0-9_-]+(\.[a-z0-9_-]+)+\.?$`)
+
+// PolicyError is returned when a request does not comply to
+// the policy and holds a message for every rule that failed.
+type PolicyError struct {
+	Violations []string
+}
+
+func (p *PolicyError) Error() string {
+	return "policy violation: " + strings.Join(p.Violations, "; ")
+}
+
+func (p *PolicyError) add(rule, format string, args ...interface{}) {
+	violation := fmt.Sprintf("rule '%s' ", rule) + fmt.Sprintf(format, args...)
+	for _, v := range p.Violations {
+		if v == violation {
+			return
+		}
+	}
+	p.Violations = append(p.Violations, violation)
+}
+
+// get returns nil when there are no violations
+func (p *PolicyError) get() error {
+	if len(p.Violations) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Policy will check the requests for certificates against the configured rules
+type Policy struct {
+	conf *config.PolicyConfig
+}
+
+func NewPolicy(conf *config.PolicyConfig) *Policy {
+	return &Policy{conf: conf}
+}
+
+// CheckRequest will check the subject, hosts, email and uri alternative
+// names and public key of a certificate request
+func (p *Policy) CheckRequest(csr *x509.CertificateRequest) error {
+	violations := new(PolicyError)
+	p.checkSubject(violations, csr.Subject)
+	dns, ips := withCommonName(csr.Subject.CommonName, csr.DNSNames, csr.IPAddresses)
+	p.checkHosts(violations, dns, ips)
+	p.checkAltNames(violations, csr.EmailAddresses, csr.URIs)
+	p.checkPublicKey(violations, csr.PublicKey)
+	return violations.get()
+}
+
+// CheckNewRequest will check the subject, hosts, email and uri alternative names
+// and key options before a new key and certificate request is created.
+func (p *Policy) CheckNewRequest(subject pkix.Name, hosts, emails []string, uris []*url.URL, options KeyOptions) error {
+	violations := new(PolicyError)
+	p.checkSubject(violations, subject)
+	dns, ips := SplitHosts(hosts)
+	dns, ips = withCommonName(subject.CommonName, dns, ips)
+	p.checkHosts(violations, dns, ips)
+	p.checkAltNames(violations, emails, uris)
+	switch keyType := options.GetType(); keyType {
+	case KEY_TYPE_ECDSA:
+		// an unsupported curve will fail when the key is generated
+		if curve, err := options.GetCurve(); err == nil {
+			p.checkKey(violations, keyType, curve.Params().BitSize)
+		}
+	default:
+		p.checkKey(violations, keyType, options.Bits)
+	}
+	return violations.get()
+}
+
+// CheckHosts will only check the hosts, this is used when the subject
+// and key are not known yet like for the orders of the acme server.
+func (p *Policy) CheckHosts(hosts []string) error {
+	violations := new(PolicyError)
+	dns, ips := SplitHosts(hosts)
+	p.checkHosts(violations, dns, ips)
+	return violations.get()
+}
+
+// CheckSshRequest will check the public key and the host names (principals)
+// of a host certificate, the principals of user certificates are not checked.
+func (p *Policy) CheckSshRequest(key crypto.PublicKey, hosts []string) error {
+	violations := new(PolicyError)
+	dns, ips := SplitHosts(hosts)
+	p.checkHosts(violations, dns, ips)
+	p.checkPublicKey(violations, key)
+	return violations.get()
+}
+
+// CopyExtensions returns the extensions of the request that are allowed to
+// be copied to the certificate by the copy_extensions rule.
+func (p *Policy) CopyExtensions(csr *x509.CertificateRequest) []pkix.Extension {
+	var extensions []pkix.Extension
+	for _, extension := range csr.Extensions {
+		for _, oid := range p.conf.CopyExtensions {
+			if extension.Id.Equal(oid) {
+				extensions = append(extensions, extension)
+				break
+			}
+		}
+	}
+	return extensions
+}
+
+// GetConflicts returns the (config.CONFLICTS_*) mode for certificates with
+// alternative names that overlap with existing certificates.
+func (p *Policy) GetConflicts() string {
+	return p.conf.Conflicts
+}
+
+func (p *Policy) checkSubject(violations *PolicyError, subject pkix.Name) {
+	for _, field := range p.conf.RequiredSubject {
+		var value []string
+		switch field {
+		case "country":
+			value = subject.Country
+		case "organization":
+			value = subject.Organization
+		case "organizational_unit":
+			value = subject.OrganizationalUnit
+		case "locality":
+			value = subject.Locality
+		case "province":
+			value = subject.Province
+		case "street_address":
+			value = subject.StreetAddress
+		case "postal_code":
+			value = subject.PostalCode
+		case "serial_number":
+			value = []string{subject.SerialNumber}
+		}
+		if len(value) == 0 || value[0] == "" {
+			violations.add("required_subject", "requires the subject field '%s'", field)
+		}
+	}
+}
+
+func (p *Policy) checkHosts(violations *PolicyError, dns []string, ips []net.IP) {
+	for _, name := range dns {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "*.") && !p.conf.AllowWildcard {
+			violations.add("allow_wildcard", "does not allow the wildcard domain '%s'", name)
+			continue
+		}
+		if pattern := matchDomain(name, p.conf.DeniedDomains); pattern != "" {
+			violations.add("denied_domains", "(%s) does not allow the domain '%s'", pattern, name)
+			continue
+		}
+		// a wildcard would also be valid for the denied domains it covers
+		if pattern := coverDomain(name, p.conf.DeniedDomains); pattern != "" {
+			violations.add("denied_domains", "(%s) does not allow the wildcard domain '%s' because it covers a denied domain", pattern, name)
+			continue
+		}
+		if len(p.conf.AllowedDomains) > 0 && matchDomain(name, p.conf.AllowedDomains) == "" {
+			violations.add("allowed_domains", "(%s) does not allow the domain '%s'", strings.Join(p.conf.AllowedDomains, ", "), name)
+		}
+	}
+	for _, ip := range ips {
+		if network := matchNetwork(ip, p.conf.DeniedIPs); network != nil {
+			violations.add("denied_ips", "(%s) does not allow the ip address '%s'", network, ip)
+			continue
+		}
+		if len(p.conf.AllowedIPs) > 0 && matchNetwork(ip, p.conf.AllowedIPs) == nil {
+			violations.add("allowed_ips", "does not allow the ip address '%s'", ip)
+		}
+	}
+}
+
+// checkAltNames will check the domain of the email addresses and the host of the
+// uris (like the trust domain of a spiffe id) against the domain and ip rules.
+func (p *Policy) checkAltNames(violations *PolicyError, emails []string, uris []*url.URL) {
+	hosts := make([]string, 0, len(emails)+len(uris))
+	for _, email := range emails {
+		if index := strings.LastIndexByte(email, '@'); index >= 0 {
+			hosts = append(hosts, email[index+1:])
+		}
+	}
+	for _, uri := range uris {
+		if host := uri.Hostname(); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	dns, ips := SplitHosts(hosts)
+	p.checkHosts(violations, dns, ips)
+}
+
+func (p *Policy) checkPublicKey(violations *PolicyError, key crypto.PublicKey) {
+	switch t := key.(type) {
+	case *rsa.PublicKey:
+		p.checkKey(violations, KEY_TYPE_RSA, t.N.BitLen())
+	case *ecdsa.PublicKey:
+		p.checkKey(violations, KEY_TYPE_ECDSA, t.Curve.Params().BitSize)
+	case ed25519.PublicKey:
+		p.checkKey(violations, KEY_TYPE_ED25519, 0)
+	default:
+		violations.add("key_types", "does not allow the key type %T", key)
+	}
+}
+
+func (p *Policy) checkKey(violations *PolicyError, keyType string, bits int) {
+	if len(p.conf.KeyTypes) > 0 {
+		var allowed bool
+		for _, t := range p.conf.KeyTypes {
+			if (KeyOptions{Type: t}).GetType() == keyType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			violations.add("key_types", "(%s) does not allow the key type '%s'", strings.Join(p.conf.KeyTypes, ", "), keyType)
+			return
+		}
+	}
+	if keyType == KEY_TYPE_RSA {
+		if bits < p.conf.MinRsaBits {
+			violations.add("min_rsa_bits", "requires a rsa key of at least %d bits, got %d", p.conf.MinRsaBits, bits)
+		}
+		if bits > p.conf.MaxRsaBits {
+			violations.add("max_rsa_bits", "allows a rsa key of at most %d bits, got %d", p.conf.MaxRsaBits, bits)
+		}
+	}
+	if keyType == KEY_TYPE_ECDSA {
+		if bits < p.conf.MinEcdsaBits {
+			violations.add("min_ecdsa_bits", "requires a ecdsa key of at least %d bits, got %d", p.conf.MinEcdsaBits, bits)
+		}
+		if bits > p.conf.MaxEcdsaBits {
+			violations.add("max_ecdsa_bits", "allows a ecdsa key of at most %d bits, got %d", p.conf.MaxEcdsaBits, bits)
+		}
+	}
+}
+
+// matchDomain returns the first pattern that matches the domain, a pattern
+// starting with `*.` or `.` will match every sub domain (on any level) and
+// also a requested wildcard of a sub domain.
+func matchDomain(domain string, patterns []string) string {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") || strings.HasPrefix(pattern, ".") {
+			suffix := pattern[strings.Index(pattern, "."):]
+			if strings.HasSuffix(domain, suffix) && len(domain) > len(suffix) {
+				return pattern
+			}
+		} else if domain == pattern {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// coverDomain returns the first (non wildcard) pattern that is covered by
+// the requested wildcard domain, so *.example.com will cover api.example.com.
+func coverDomain(domain string, patterns []string) string {
+	if !strings.HasPrefix(domain, "*.") {
+		return ""
+	}
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "*.") && !strings.HasPrefix(pattern, ".") && util.ValidHost(pattern, domain) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// withCommonName returns the dns names and ip addresses with the common name
+// added when it is an ip address or looks like a dns name. Clients can still
+// use the common name as host so it should comply to the same rules.
+func withCommonName(cn string, dns []string, ips []net.IP) ([]string, []net.IP) {
+	if ip := net.ParseIP(cn); ip != nil {
+		return dns, append(ips[:len(ips):len(ips)], ip)
+	}
+	if hostPattern.MatchString(cn) {
+		return append(dns[:len(dns):len(dns)], cn), ips
+	}
+	return dns, ips
+}
+
+func matchNetwork(ip net.IP, networks []*net.IPNet) *net.IPNet {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
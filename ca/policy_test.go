@@ -0,0 +1,217 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pbergman/caserver/config"
+)
+
+func TestPolicy_CheckNewRequest(t *testing.T) {
+	conf := new(config.Config)
+	policy := conf.GetPolicy(config.DEFAULT_CA)
+	policy.AllowedDomains = []string{"*.dev.example.com", "example.test"}
+	policy.DeniedDomains = []string{"*.prod.dev.example.com"}
+	policy.AllowWildcard = false
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	policy.AllowedIPs = []*net.IPNet{network}
+	policy.KeyTypes = []string{"rsa", "ecdsa"}
+	policy.MaxEcdsaBits = 256
+	policy.RequiredSubject = []string{"organization"}
+
+	subject := pkix.Name{CommonName: "example", Organization: []string{"example"}}
+	rsa := KeyOptions{Type: KEY_TYPE_RSA, Bits: 2048}
+
+	for _, c := range []struct {
+		subject pkix.Name
+		hosts   []string
+		options KeyOptions
+		rule    string
+	}{
+		{subject, []string{"api.dev.example.com", "example.test", "10.1.2.3"}, rsa, ""},
+		{subject, []string{"a.b.dev.example.com"}, KeyOptions{Type: KEY_TYPE_ECDSA}, ""},
+		{pkix.Name{CommonName: "example"}, []string{"api.dev.example.com"}, rsa, "required_subject"},
+		{subject, []string{"api.example.com"}, rsa, "allowed_domains"},
+		{subject, []string{"dev.example.com"}, rsa, "allowed_domains"},
+		{subject, []string{"api.prod.dev.example.com"}, rsa, "denied_domains"},
+		{subject, []string{"*.dev.example.com"}, rsa, "allow_wildcard"},
+		{subject, []string{"192.168.1.1"}, rsa, "allowed_ips"},
+		{subject, []string{"example.test"}, KeyOptions{Type: KEY_TYPE_RSA, Bits: 100000}, "max_rsa_bits"},
+		{subject, []string{"example.test"}, KeyOptions{Type: KEY_TYPE_RSA, Bits: 1024}, "min_rsa_bits"},
+		{subject, []string{"example.test"}, KeyOptions{Type: KEY_TYPE_ED25519}, "key_types"},
+		{subject, []string{"example.test"}, KeyOptions{Type: KEY_TYPE_ECDSA, Curve: "P-384"}, "max_ecdsa_bits"},
+	} {
+		err := NewPolicy(policy).CheckNewRequest(c.subject, c.hosts, nil, nil, c.options)
+
+		if c.rule == "" {
+			if err != nil {
+				t.Fatalf("expected %v to be allowed got: %s", c.hosts, err)
+			}
+			continue
+		}
+
+		e, ok := err.(*PolicyError)
+
+		if !ok || len(e.Violations) != 1 || e.Violations[0][:len("rule '"+c.rule+"'")] != "rule '"+c.rule+"'" {
+			t.Fatalf("expected a violation of rule '%s' for %v got: %v", c.rule, c.hosts, err)
+		}
+	}
+}
+
+func TestPolicy_DeniedWildcard(t *testing.T) {
+	conf := new(config.Config)
+	policy := conf.GetPolicy(config.DEFAULT_CA)
+	policy.DeniedDomains = []string{"secret.example.com"}
+
+	for host, allowed := range map[string]bool{
+		"*.example.com":        false,
+		"*.secret.example.com": true,
+		"*.api.example.com":    true,
+		"api.example.com":      true,
+	} {
+		if err := NewPolicy(policy).CheckHosts([]string{host}); (err == nil) != allowed {
+			t.Fatalf("expected '%s' allowed to be %v got: %v", host, allowed, err)
+		}
+	}
+}
+
+func TestPolicy_CommonName(t *testing.T) {
+	policy := new(config.Config).GetPolicy(config.DEFAULT_CA)
+	policy.AllowedDomains = []string{"*.allowed.com"}
+	policy.DeniedDomains = []string{"*.denied.com"}
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	policy.AllowedIPs = []*net.IPNet{network}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for cn, rule := range map[string]string{
+		"host.denied.com": "denied_domains",
+		"host.other.com":  "allowed_domains",
+		"192.168.1.1":     "allowed_ips",
+		"ok.allowed.com":  "",
+		"example":         "",
+		"Example Service": "",
+	} {
+		// a request without alternative names
+		raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		csr, err := x509.ParseCertificateRequest(raw)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, err := range []error{
+			NewPolicy(policy).CheckRequest(csr),
+			NewPolicy(policy).CheckNewRequest(pkix.Name{CommonName: cn}, []string{"ok.allowed.com"}, nil, nil, KeyOptions{Type: KEY_TYPE_ECDSA}),
+		} {
+			if rule == "" && err != nil {
+				t.Fatalf("expected the common name '%s' to be allowed got: %s", cn, err)
+			}
+
+			if e, ok := err.(*PolicyError); rule != "" && (!ok || len(e.Violations) != 1 || !strings.HasPrefix(e.Violations[0], "rule '"+rule+"'")) {
+				t.Fatalf("expected a violation of rule '%s' for the common name '%s' got: %v", rule, cn, err)
+			}
+		}
+	}
+}
+
+func TestPolicy_AltNames(t *testing.T) {
+	policy := new(config.Config).GetPolicy(config.DEFAULT_CA)
+	policy.AllowedDomains = []string{"allowed.com", "*.allowed.com"}
+	policy.DeniedDomains = []string{"denied.allowed.com"}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		email string
+		uri   string
+		rule  string
+	}{
+		{"admin@allowed.com", "spiffe://allowed.com/service", ""},
+		{"admin@api.allowed.com", "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", ""},
+		{"admin@denied.com", "spiffe://allowed.com/service", "allowed_domains"},
+		{"admin@allowed.com", "spiffe://denied.com/service", "allowed_domains"},
+		{"admin@denied.allowed.com", "spiffe://allowed.com/service", "denied_domains"},
+		{"admin@allowed.com", "https://denied.allowed.com:8443/service", "denied_domains"},
+	} {
+		uri, _ := url.Parse(c.uri)
+		raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "example"}, EmailAddresses: []string{c.email}, URIs: []*url.URL{uri}}, key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		csr, err := x509.ParseCertificateRequest(raw)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, err := range []error{
+			NewPolicy(policy).CheckRequest(csr),
+			NewPolicy(policy).CheckNewRequest(pkix.Name{CommonName: "example"}, nil, []string{c.email}, []*url.URL{uri}, KeyOptions{Type: KEY_TYPE_ECDSA}),
+		} {
+			if c.rule == "" && err != nil {
+				t.Fatalf("expected %s and %s to be allowed got: %s", c.email, c.uri, err)
+			}
+
+			if e, ok := err.(*PolicyError); c.rule != "" && (!ok || len(e.Violations) != 1 || !strings.HasPrefix(e.Violations[0], "rule '"+c.rule+"'")) {
+				t.Fatalf("expected a violation of rule '%s' for %s and %s got: %v", c.rule, c.email, c.uri, err)
+			}
+		}
+	}
+}
+
+func TestPolicy_CheckRequestCurve(t *testing.T) {
+	policy := new(config.Config).GetPolicy(config.DEFAULT_CA)
+
+	for curve, rule := range map[elliptic.Curve]string{elliptic.P224(): "min_ecdsa_bits", elliptic.P256(): ""} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "example"}, DNSNames: []string{"example.com"}}, key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		csr, err := x509.ParseCertificateRequest(raw)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = NewPolicy(policy).CheckRequest(csr)
+
+		if rule == "" && err != nil {
+			t.Fatalf("expected a %s key to be allowed got: %s", curve.Params().Name, err)
+		}
+
+		if e, ok := err.(*PolicyError); rule != "" && (!ok || !strings.HasPrefix(e.Violations[0], "rule '"+rule+"'")) {
+			t.Fatalf("expected a violation of rule '%s' for a %s key got: %v", rule, curve.Params().Name, err)
+		}
+	}
+}
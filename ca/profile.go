@@ -0,0 +1,37 @@
+package ca
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/pbergman/caserver/config"
+)
+
+// CheckProfile will validate that the given subject alternative names
+// are allowed by the profile and returns an error for the first type
+// that is not allowed.
+func CheckProfile(profile *config.ProfileConfig, dns []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	for name, size := range map[string]int{
+		config.SAN_TYPE_DNS:   len(dns),
+		config.SAN_TYPE_IP:    len(ips),
+		config.SAN_TYPE_EMAIL: len(emails),
+		config.SAN_TYPE_URI:   len(uris),
+	} {
+		if size > 0 && !profile.AllowsSanType(name) {
+			return newRequestError("profile '%s' does not allow %s subject alternative names", profile.Name, name)
+		}
+	}
+	return nil
+}
+
+// SplitHosts will split the hosts in dns names and ip addresses
+func SplitHosts(hosts []string) (dns []string, ips []net.IP) {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dns = append(dns, host)
+		}
+	}
+	return
+}
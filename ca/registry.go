@@ -0,0 +1,42 @@
+package ca
+
+import (
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/storage"
+)
+
+// NewRegistry will create a manager for every configured CA, the storage
+// callback should return the storage that will be used for the named CA.
+func NewRegistry(conf *config.Config, call func(string) storage.Storage) (*Registry, error) {
+	registry := &Registry{managers: make(map[string]*Manager), config: conf}
+	for _, authority := range conf.Authorities {
+		manager, err := NewManager(conf, authority, call(authority.Name))
+		if err != nil {
+			return nil, err
+		}
+		registry.managers[authority.Name] = manager
+		registry.names = append(registry.names, authority.Name)
+	}
+	return registry, nil
+}
+
+// Registry holds the managers of all configured certificate authorities.
+type Registry struct {
+	managers map[string]*Manager
+	names    []string
+	config   *config.Config
+}
+
+// Get will return the manager for the given CA name or nil when not
+// found, an empty name will return the manager of the default CA.
+func (r *Registry) Get(name string) *Manager {
+	if authority := r.config.GetAuthority(name); authority != nil {
+		return r.managers[authority.Name]
+	}
+	return nil
+}
+
+// Names returns the names of the CA`s in the order they were configured.
+func (r *Registry) Names() []string {
+	return r.names
+}
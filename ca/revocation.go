@@ -0,0 +1,69 @@
+package ca
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the revocation reason codes as defined in rfc5280 5.3.1, the
+// removeFromCRL (8) is not supported because it is only used
+// in delta CRL`s.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keycompromise":        1,
+	"cacompromise":         2,
+	"affiliationchanged":   3,
+	"superseded":           4,
+	"cessationofoperation": 5,
+	"certificatehold":      6,
+	"privilegewithdrawn":   9,
+	"aacompromise":         10,
+}
+
+// ParseRevocationReason will parse the reason code or name (case
+// insensitive and without '_' or '-') to a rfc5280 reason code.
+func ParseRevocationReason(reason string) (int, error) {
+	if reason == "" {
+		return 0, nil
+	}
+	if code, err := strconv.Atoi(reason); err == nil {
+		for _, value := range revocationReasons {
+			if value == code {
+				return code, nil
+			}
+		}
+		return 0, fmt.Errorf("unsupported revocation reason code %d", code)
+	}
+	name := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(reason))
+	if code, ok := revocationReasons[name]; ok {
+		return code, nil
+	}
+	return 0, fmt.Errorf("unsupported revocation reason '%s'", reason)
+}
+
+// RevocationReasonName returns the rfc5280 name of the reason code
+func RevocationReasonName(code int) string {
+	switch code {
+	case 0:
+		return "unspecified"
+	case 1:
+		return "keyCompromise"
+	case 2:
+		return "cACompromise"
+	case 3:
+		return "affiliationChanged"
+	case 4:
+		return "superseded"
+	case 5:
+		return "cessationOfOperation"
+	case 6:
+		return "certificateHold"
+	case 9:
+		return "privilegeWithdrawn"
+	case 10:
+		return "aACompromise"
+	default:
+		return strconv.Itoa(code)
+	}
+}
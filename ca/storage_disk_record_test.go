@@ -2,6 +2,7 @@ package ca
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -24,7 +25,7 @@ func newTestCa(f FactoryInterface, t *testing.T) (*rsa.PrivateKey, *x509.Certifi
 		t.Fatal(err)
 	}
 
-	cer, err := f.NewCertificateAuthority(key, pkix.Name{CommonName: "example CA"})
+	cer, err := f.NewCertificateAuthority(key, pkix.Name{CommonName: "example CA"}, nil)
 
 	if err != nil {
 		t.Fatal(err)
@@ -46,17 +47,17 @@ func TestDiskRecord_PemLen(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	factory := NewFactory([3]int{1, 2, 3}, [3]int{4, 5, 6}, nil)
+	factory := NewFactory([3]int{1, 2, 3}, [3]int{4, 5, 6})
 	// new CA key and certificate
 	ck, cc := newTestCa(factory, t)
 
-	csr, err := factory.NewCertificateRequest(key, pkix.Name{CommonName: "example"}, []string{"example.com"})
+	csr, err := factory.NewCertificateRequest(key, pkix.Name{CommonName: "example"}, []string{"example.com"}, nil, nil)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	cer, err := factory.NewCertificate(csr, cc, ck)
+	cer, err := factory.NewCertificate(csr, cc, ck, nil)
 
 	if err != nil {
 		t.Fatal(err)
@@ -98,17 +99,17 @@ func TestDiskRecord_Marshal(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	factory := NewFactory([3]int{1, 2, 3}, [3]int{4, 5, 6}, nil)
+	factory := NewFactory([3]int{1, 2, 3}, [3]int{4, 5, 6})
 
 	ck, cc := newTestCa(factory, t)
 
-	csr, err := factory.NewCertificateRequest(key, pkix.Name{CommonName: "TEST", Country: []string{"NL"}}, []string{"example.com", "*.example.com"})
+	csr, err := factory.NewCertificateRequest(key, pkix.Name{CommonName: "TEST", Country: []string{"NL"}}, []string{"example.com", "*.example.com"}, nil, nil)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	cer, err := factory.NewCertificate(csr, cc, ck)
+	cer, err := factory.NewCertificate(csr, cc, ck, nil)
 
 	if err != nil {
 		t.Fatal(err)
@@ -145,3 +146,60 @@ func TestDiskRecord_Marshal(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDiskRecord_MarshalKeyTypes(t *testing.T) {
+	factory := NewFactory([3]int{1, 2, 3}, [3]int{4, 5, 6})
+	ds := newDiskStorage()
+
+	for _, options := range []KeyOptions{{Type: "ecdsa", Curve: "P-256"}, {Type: "ecdsa", Curve: "P-384"}, {Type: "ed25519"}} {
+		key, err := options.Generate()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// use the same key type for the CA so both signing and creating are tested
+		cer, err := factory.NewCertificateAuthority(key, pkix.Name{CommonName: "example CA"}, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		csr, err := factory.NewCertificateRequest(key, pkix.Name{CommonName: "example"}, []string{"example.com"}, nil, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := factory.NewCertificate(csr, cer, key, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		record := storage.NewDiskRecord(ds, nil)
+		record.SetCertificate(cer)
+		record.SetPrivateKey(key)
+
+		buf, err := record.MarshalBinary()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newRecord := storage.NewDiskRecord(ds, nil)
+
+		if err := newRecord.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if newRecord.GetPrivateKey() == nil || !cer.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(newRecord.GetPrivateKey().Public()) {
+			t.Fatalf("expected unmarshalled %s key to match the original", options.Type)
+		}
+
+		out := new(bytes.Buffer)
+		newRecord.WritePrivateKey(out)
+
+		if out.Len() != int(newRecord.BlockKeyLen()) {
+			t.Fatalf("Expected %d got %d", out.Len(), newRecord.BlockKeyLen())
+		}
+	}
+}
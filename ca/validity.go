@@ -0,0 +1,50 @@
+package ca
+
+import (
+	"time"
+)
+
+// the time a not before can be in the past to allow for clock skew
+const validitySkew = 5 * time.Minute
+
+// Validity is the requested validity period of a certificate, when not
+// set the validity will be based on the pem_not_after of the config. The
+// NotAfter and TTL are exclusive, the TTL is relative to the NotBefore.
+type Validity struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	TTL       time.Duration
+}
+
+// Validate checks if the requested validity is a valid period, the not
+// before can not be backdated more than a few minutes.
+func (v Validity) Validate() error {
+	if !v.NotAfter.IsZero() && v.TTL != 0 {
+		return newRequestError("the not_after and ttl of the validity can not be combined")
+	}
+	if v.TTL < 0 {
+		return newRequestError("the ttl of the validity should be positive")
+	}
+	if !v.NotBefore.IsZero() && v.NotBefore.Before(time.Now().Add(-validitySkew)) {
+		return newRequestError("the not_before of the validity can not be more than %s in the past", validitySkew)
+	}
+	if !v.NotAfter.IsZero() && !v.NotBefore.IsZero() && !v.NotAfter.After(v.NotBefore) {
+		return newRequestError("the not_after of the validity should be after the not_before")
+	}
+	return nil
+}
+
+// getNotAfter returns the requested end of the validity
+// or a zero time when the default should be used.
+func (v Validity) getNotAfter(now time.Time) (time.Time, error) {
+	if err := v.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	if v.TTL > 0 {
+		if v.NotBefore.IsZero() {
+			return now.Add(v.TTL), nil
+		}
+		return v.NotBefore.Add(v.TTL), nil
+	}
+	return v.NotAfter, nil
+}
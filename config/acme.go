@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/ini.v1"
+)
+
+// AcmeConfig holds the options of the (rfc8555) ACME server
+type AcmeConfig struct {
+	// the acme endpoints are disabled unless enabled in the config
+	// because every account can request certificates for the hosts
+	// it can validate (within the policy of the CA).
+	Enabled bool
+	// when true the challenges are not validated and every authorization
+	// will be valid when created, this should only be used for development.
+	TrustAll bool
+	// the profile used for the issued certificates
+	Profile string `default:"server"`
+	// the port that is used for the http-01 validation
+	HttpPort int `default:"80"`
+	// the dns server (host:port) that is used for the dns-01
+	// validation, when empty the system resolver will be used.
+	Resolver string
+}
+
+func (c *Config) readAcmeSection(conf *ini.Section) error {
+	for key, dst := range map[string]*bool{"enabled": &c.Acme.Enabled, "trust_all": &c.Acme.TrustAll} {
+		if conf.HasKey(key) {
+			if v, err := conf.Key(key).Bool(); err == nil {
+				*dst = v
+			} else {
+				return fmt.Errorf("invalid %s '%s' (%s)", key, conf.Key(key).String(), conf.Name())
+			}
+		}
+	}
+	if conf.HasKey("profile") {
+		c.Acme.Profile = conf.Key("profile").String()
+	}
+	if c.GetProfile(c.Acme.Profile) == nil {
+		return fmt.Errorf("unknown profile '%s' (%s)", c.Acme.Profile, conf.Name())
+	}
+	if conf.HasKey("http_port") {
+		if v, err := conf.Key("http_port").Int(); err == nil && v > 0 && v < 65536 {
+			c.Acme.HttpPort = v
+		} else {
+			return fmt.Errorf("invalid http_port '%s' (%s)", conf.Key("http_port").String(), conf.Name())
+		}
+	}
+	if conf.HasKey("resolver") {
+		c.Acme.Resolver = conf.Key("resolver").String()
+	}
+	return nil
+}
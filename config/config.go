@@ -4,23 +4,157 @@ import (
 	"crypto/sha256"
 	"crypto/x509/pkix"
 	"errors"
+	"fmt"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pbergman/caserver/util"
 	"gopkg.in/ini.v1"
 )
 
+// DEFAULT_CA is the name of the CA defined in the `[ca]` section
+const DEFAULT_CA string = "default"
+
+var (
+	// matches the named ca sections like: [ca "staging"]
+	sectionCa = regexp.MustCompile(`^ca\s+"(.*)"$`)
+	// matches the profile sections like: [profile "client"]
+	sectionProfile = regexp.MustCompile(`^profile\s+"(.*)"$`)
+	// the allowed characters for a CA name as used in urls
+	validCaName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	// names that would collide with the api routes
+	reservedCaNames = []string{"bundle", "ca", "cert", "crl", "csr", "issuer", "list", "ocsp", "rollover", "ssh"}
+)
+
 type AppConfig struct {
 	Path        string `default:"/var/lib/caserver"`
 	Address     string `default:":8080"`
 	Key         [32]byte
 	CaNotAfter  [3]int `default:"10"`
 	PemNotAfter [3]int `default:"10"`
+	// the max validity that can be requested for a
+	// certificate, defaults to the PemNotAfter.
+	PemMaxNotAfter [3]int
+	// the public url of the server used in certificate
+	// extensions like the CRL distribution point.
+	Url string
+	// the interval for regenerating the CRL`s
+	CrlInterval time.Duration `default:"1h"`
+	// the interval for checking the expiry of the certificates
+	// and the period before expiry that a certificate is expiring
+	ExpiryInterval time.Duration `default:"24h"`
+	ExpiryWarning  time.Duration `default:"720h"`
+	// the certificate and key for serving https, which
+	// is needed for client certificate authentication.
+	TlsCert string
+	TlsKey  string
+}
+
+// GetUrl returns the public url of the server, when not configured
+// it will be based on the address the server is listening on.
+func (a AppConfig) GetUrl() string {
+	if a.Url != "" {
+		return strings.TrimRight(a.Url, "/")
+	}
+	scheme := "http://"
+	if a.TlsCert != "" {
+		scheme = "https://"
+	}
+	host, port, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return scheme + a.Address
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
+
+// GetPemMaxNotAfter returns the max not after for a certificate issued at the given time
+func (a AppConfig) GetPemMaxNotAfter(now time.Time) time.Time {
+	max := a.PemMaxNotAfter
+	if max == [3]int{} {
+		max = a.PemNotAfter
+	}
+	return now.AddDate(max[0], max[1], max[2]).UTC()
+}
+
+// KeyConfig holds the options used for generating a private key.
+type KeyConfig struct {
+	Type  string `default:"rsa" default[ssh]:"ed25519"`
+	Bits  int    `default:"2048"`
+	Curve string `default:"P-256"`
+}
+
+// CaConfig holds the configuration of a certificate authority
+type CaConfig struct {
+	Name    string
+	Subject *pkix.Name
+	Key     KeyConfig
+	// the number of intermediate certificates between
+	// the root and the issued certificates.
+	Intermediates int `default:"1"`
+	// the names the CA is allowed to issue certificates for
+	NameConstraints NameConstraints
+	// an existing root that is imported when the CA is created
+	Import ImportConfig
+}
+
+// ImportConfig holds the files of an existing root certificate and key
+// that will be used instead of generating a new root for an empty storage.
+type ImportConfig struct {
+	Cert string
+	Key  string
+	// the password of an encrypted key
+	Password string
+}
+
+// NameConstraints holds the (rfc5280 4.2.1.10) name constraints of a CA, a
+// dns constraint like `example.com` will match the domain and sub domains
+// and `.example.com` will only match the sub domains.
+type NameConstraints struct {
+	PermittedDNS    []string
+	ExcludedDNS     []string
+	PermittedIPs    []*net.IPNet
+	ExcludedIPs     []*net.IPNet
+	PermittedEmails []string
+	ExcludedEmails  []string
+}
+
+// IsEmpty checks if no constraints are configured
+func (n NameConstraints) IsEmpty() bool {
+	return len(n.PermittedDNS)+len(n.ExcludedDNS)+len(n.PermittedIPs)+len(n.ExcludedIPs)+len(n.PermittedEmails)+len(n.ExcludedEmails) == 0
 }
 
 type Config struct {
-	AppConfig `ini:"app"`
-	CaSubject *pkix.Name `ini:"ca"`
+	AppConfig   `ini:"app"`
+	Authorities []*CaConfig      `ini:"ca"`
+	Profiles    []*ProfileConfig `ini:"profile"`
+	Policies    []*PolicyConfig  `ini:"policy"`
+	Acme        AcmeConfig       `ini:"acme"`
+	Est         EstConfig        `ini:"est"`
+	Ssh         SshConfig        `ini:"ssh"`
+}
+
+// GetAuthority will return the CA config for the given name or nil
+// when not found, an empty name will return the default CA config.
+func (c *Config) GetAuthority(name string) *CaConfig {
+	if name == "" {
+		name = DEFAULT_CA
+	}
+	for _, authority := range c.Authorities {
+		if authority.Name == name {
+			return authority
+		}
+	}
+	// when no [ca] section is defined the first will be used as default
+	if name == DEFAULT_CA && len(c.Authorities) > 0 {
+		return c.Authorities[0]
+	}
+	return nil
 }
 
 func (c *Config) parseIntArray(value string, dst *[3]int) {
@@ -49,23 +183,115 @@ func (c *Config) Read(source string) error {
 		return err
 	}
 
+	// the built-in profiles are set once before the profile
+	// sections are read so these can be modified by the config
+	if c.Profiles == nil {
+		c.Profiles = defaultProfiles()
+	}
+
 	if section, err := cfg.GetSection("app"); err == nil {
 		if err := c.readAppSection(section); err != nil {
 			return err
 		}
 	}
 
-	if section, err := cfg.GetSection("ca"); err == nil {
-		if c.CaSubject == nil {
-			c.CaSubject = new(pkix.Name)
+	for _, section := range cfg.Sections() {
+		if match := sectionProfile.FindStringSubmatch(section.Name()); match != nil {
+			if err := c.readProfileSection(section, match[1]); err != nil {
+				return err
+			}
+			continue
+		}
+		if section.Name() == "policy" {
+			if err := c.readPolicySection(section, ""); err != nil {
+				return err
+			}
+			continue
 		}
-		if err := c.readCaSection(section, c.CaSubject); err != nil {
+		if match := sectionPolicy.FindStringSubmatch(section.Name()); match != nil {
+			if err := c.readPolicySection(section, match[1]); err != nil {
+				return err
+			}
+			continue
+		}
+		var name string
+		if section.Name() == "ca" {
+			name = DEFAULT_CA
+		} else if match := sectionCa.FindStringSubmatch(section.Name()); match != nil {
+			name = match[1]
+		} else {
+			continue
+		}
+		if err := c.readAuthoritySection(section, name); err != nil {
 			return err
 		}
-	} else {
+	}
+
+	if len(c.Authorities) == 0 {
 		return errors.New("missing required `ca` section in config")
 	}
 
+	// read after the profiles so the configured profile can be checked
+	if section, err := cfg.GetSection("acme"); err == nil {
+		if err := c.readAcmeSection(section); err != nil {
+			return err
+		}
+	}
+
+	if section, err := cfg.GetSection("est"); err == nil {
+		if err := c.readEstSection(section); err != nil {
+			return err
+		}
+	}
+
+	if section, err := cfg.GetSection("ssh"); err == nil {
+		if err := c.readSshSection(section); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) readAuthoritySection(conf *ini.Section, name string) error {
+	if !validCaName.MatchString(name) {
+		return fmt.Errorf("invalid ca name '%s', only lowercase letters, digits, '-' and '_' are allowed", name)
+	}
+	for _, reserved := range reservedCaNames {
+		if name == reserved {
+			return fmt.Errorf("invalid ca name '%s', the name is reserved", name)
+		}
+	}
+	authority := c.GetAuthority(name)
+	if authority == nil || authority.Name != name {
+		authority = &CaConfig{Name: name, Subject: new(pkix.Name)}
+		util.SetDefaults(authority)
+		c.Authorities = append(c.Authorities, authority)
+	}
+	if err := c.readCaSection(conf, authority.Subject); err != nil {
+		return fmt.Errorf("%s (%s)", err, conf.Name())
+	}
+	c.readKeySection(conf, &authority.Key)
+	if err := c.readNameConstraints(conf, &authority.NameConstraints); err != nil {
+		return err
+	}
+	if conf.HasKey("intermediates") {
+		if v, err := conf.Key("intermediates").Int(); err == nil && v >= 0 {
+			authority.Intermediates = v
+		}
+	}
+	if conf.HasKey("import_cert") {
+		authority.Import.Cert = conf.Key("import_cert").String()
+	}
+	if conf.HasKey("import_key") {
+		authority.Import.Key = conf.Key("import_key").String()
+	}
+	if conf.HasKey("import_key_password") {
+		authority.Import.Password = conf.Key("import_key_password").String()
+	}
+	if (authority.Import.Cert == "") != (authority.Import.Key == "") {
+		return fmt.Errorf("both import_cert and import_key are required to import a CA (%s)", conf.Name())
+	}
 	return nil
 }
 
@@ -107,6 +333,46 @@ func (c *Config) readCaSection(conf *ini.Section, ca *pkix.Name) error {
 	}
 }
 
+func (c *Config) readKeySection(conf *ini.Section, key *KeyConfig) {
+	if conf.HasKey("key_type") {
+		key.Type = conf.Key("key_type").String()
+	}
+	if conf.HasKey("bits") {
+		if v, err := conf.Key("bits").Int(); err == nil {
+			key.Bits = v
+		}
+	}
+	if conf.HasKey("curve") {
+		key.Curve = conf.Key("curve").String()
+	}
+}
+
+func (c *Config) readNameConstraints(conf *ini.Section, constraints *NameConstraints) error {
+	for key, dst := range map[string]*[]string{
+		"permitted_dns":    &constraints.PermittedDNS,
+		"excluded_dns":     &constraints.ExcludedDNS,
+		"permitted_emails": &constraints.PermittedEmails,
+		"excluded_emails":  &constraints.ExcludedEmails,
+	} {
+		if conf.HasKey(key) {
+			*dst = c.readList(conf.Key(key))
+		}
+	}
+	for key, dst := range map[string]*[]*net.IPNet{"permitted_ips": &constraints.PermittedIPs, "excluded_ips": &constraints.ExcludedIPs} {
+		if conf.HasKey(key) {
+			*dst = make([]*net.IPNet, 0)
+			for _, value := range c.readList(conf.Key(key)) {
+				network, err := parseNetwork(value)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s' (%s)", key, value, conf.Name())
+				}
+				*dst = append(*dst, network)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Config) readAppSection(conf *ini.Section) error {
 	if conf.HasKey("path") {
 		c.Path = conf.Key("path").String()
@@ -123,5 +389,36 @@ func (c *Config) readAppSection(conf *ini.Section) error {
 	if conf.HasKey("pem_not_after") {
 		c.parseIntArray(conf.Key("pem_not_after").String(), &c.PemNotAfter)
 	}
+	if conf.HasKey("pem_max_not_after") {
+		c.parseIntArray(conf.Key("pem_max_not_after").String(), &c.PemMaxNotAfter)
+	}
+	if conf.HasKey("url") {
+		c.Url = conf.Key("url").String()
+	}
+	if conf.HasKey("crl_interval") {
+		if v, err := conf.Key("crl_interval").Duration(); err == nil && v > 0 {
+			c.CrlInterval = v
+		} else {
+			return fmt.Errorf("invalid crl_interval '%s'", conf.Key("crl_interval").String())
+		}
+	}
+	for key, dst := range map[string]*time.Duration{"expiry_interval": &c.ExpiryInterval, "expiry_warning": &c.ExpiryWarning} {
+		if conf.HasKey(key) {
+			if v, err := util.ParseDuration(conf.Key(key).String()); err == nil && v > 0 {
+				*dst = v
+			} else {
+				return fmt.Errorf("invalid %s '%s'", key, conf.Key(key).String())
+			}
+		}
+	}
+	if conf.HasKey("tls_cert") {
+		c.TlsCert = conf.Key("tls_cert").String()
+	}
+	if conf.HasKey("tls_key") {
+		c.TlsKey = conf.Key("tls_key").String()
+	}
+	if (c.TlsCert == "") != (c.TlsKey == "") {
+		return errors.New("the tls_cert and tls_key should both be set")
+	}
 	return nil
 }
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/ini.v1"
+)
+
+// EstConfig holds the options of the (rfc7030) EST enrollment endpoints
+type EstConfig struct {
+	// the est endpoints are disabled unless enabled in the config
+	Enabled bool
+	// the credentials for the http basic authentication, when
+	// empty the clients can only authenticate with a certificate.
+	Username string
+	Password string
+	// the profile used for the issued certificates
+	Profile string `default:"default"`
+}
+
+func (c *Config) readEstSection(conf *ini.Section) error {
+	if conf.HasKey("enabled") {
+		if v, err := conf.Key("enabled").Bool(); err == nil {
+			c.Est.Enabled = v
+		} else {
+			return fmt.Errorf("invalid enabled '%s' (%s)", conf.Key("enabled").String(), conf.Name())
+		}
+	}
+	if conf.HasKey("username") {
+		c.Est.Username = conf.Key("username").String()
+	}
+	if conf.HasKey("password") {
+		c.Est.Password = conf.Key("password").String()
+	}
+	if (c.Est.Username == "") != (c.Est.Password == "") {
+		return fmt.Errorf("the username and password should both be set (%s)", conf.Name())
+	}
+	if conf.HasKey("profile") {
+		c.Est.Profile = conf.Key("profile").String()
+	}
+	if c.GetProfile(c.Est.Profile) == nil {
+		return fmt.Errorf("unknown profile '%s' (%s)", c.Est.Profile, conf.Name())
+	}
+	return nil
+}
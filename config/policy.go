@@ -0,0 +1,225 @@
+package config
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pbergman/caserver/util"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// the modes for a new certificate with alternative names that overlap
+	// with an existing certificate: refuse, return the existing or create it.
+	CONFLICTS_REJECT string = "reject"
+	CONFLICTS_RETURN string = "return"
+	CONFLICTS_ALLOW  string = "allow"
+)
+
+var (
+	// matches the named policy sections like: [policy "staging"]
+	sectionPolicy = regexp.MustCompile(`^policy\s+"(.*)"$`)
+	// the subject fields that can be required by a policy
+	subjectFields = []string{"country", "organization", "organizational_unit", "locality", "province", "street_address", "postal_code", "serial_number"}
+	// the extensions that are set by the CA (or profile) and can not be copied from a request
+	reservedExtensions = map[string]string{
+		"2.5.29.14":            "subject key identifier",
+		"2.5.29.15":            "key usage",
+		"2.5.29.17":            "subject alternative name",
+		"2.5.29.19":            "basic constraints",
+		"2.5.29.30":            "name constraints",
+		"2.5.29.31":            "crl distribution points",
+		"2.5.29.32":            "certificate policies",
+		"2.5.29.35":            "authority key identifier",
+		"2.5.29.36":            "policy constraints",
+		"2.5.29.37":            "extended key usage",
+		"2.5.29.54":            "inhibit any policy",
+		"1.3.6.1.5.5.7.1.1":    "authority information access",
+		"1.3.6.1.5.5.7.48.1.5": "ocsp no check",
+	}
+)
+
+// PolicyConfig holds the rules that are checked before a certificate
+// is issued, the policy without a name applies to every CA that has
+// no policy of its own.
+type PolicyConfig struct {
+	Name string
+	// the domain patterns (like *.example.com) that may or may not be
+	// requested, an empty allowed list will allow every domain.
+	AllowedDomains []string
+	DeniedDomains  []string
+	// when false no wildcard domains can be requested
+	AllowWildcard bool `default:"true"`
+	// the ip ranges that may or may not be requested, an
+	// empty allowed list will allow every ip address.
+	AllowedIPs []*net.IPNet
+	DeniedIPs  []*net.IPNet
+	// the allowed key types, empty allows all types
+	KeyTypes   []string
+	MinRsaBits int `default:"2048"`
+	MaxRsaBits int `default:"8192"`
+	// the size of the curve of ecdsa keys (like 256 for P-256)
+	MinEcdsaBits int `default:"256"`
+	MaxEcdsaBits int `default:"521"`
+	// the subject fields that are required besides the common name
+	RequiredSubject []string
+	// the extensions (object identifiers) of a certificate request
+	// that will be copied to the certificate
+	CopyExtensions []asn1.ObjectIdentifier
+	// what to do when the alternative names overlap with an existing certificate
+	Conflicts string `default:"reject"`
+}
+
+// GetPolicy will return the policy for the CA with the given name, this will
+// be the policy of the CA, the global policy or a policy with the defaults.
+func (c *Config) GetPolicy(name string) *PolicyConfig {
+	var global *PolicyConfig
+	for _, policy := range c.Policies {
+		if policy.Name == name {
+			return policy
+		}
+		if policy.Name == "" {
+			global = policy
+		}
+	}
+	if global == nil {
+		global = new(PolicyConfig)
+		util.SetDefaults(global)
+	}
+	return global
+}
+
+func (c *Config) readPolicySection(conf *ini.Section, name string) error {
+	if name != "" && !validCaName.MatchString(name) {
+		return fmt.Errorf("invalid policy name '%s', only lowercase letters, digits, '-' and '_' are allowed", name)
+	}
+	policy := &PolicyConfig{Name: name}
+	util.SetDefaults(policy)
+	c.Policies = append(c.Policies, policy)
+	if conf.HasKey("allowed_domains") {
+		policy.AllowedDomains = c.readList(conf.Key("allowed_domains"))
+	}
+	if conf.HasKey("denied_domains") {
+		policy.DeniedDomains = c.readList(conf.Key("denied_domains"))
+	}
+	if conf.HasKey("allow_wildcard") {
+		if v, err := conf.Key("allow_wildcard").Bool(); err == nil {
+			policy.AllowWildcard = v
+		} else {
+			return fmt.Errorf("invalid allow_wildcard '%s' (%s)", conf.Key("allow_wildcard").String(), conf.Name())
+		}
+	}
+	for key, dst := range map[string]*[]*net.IPNet{"allowed_ips": &policy.AllowedIPs, "denied_ips": &policy.DeniedIPs} {
+		if conf.HasKey(key) {
+			for _, value := range c.readList(conf.Key(key)) {
+				network, err := parseNetwork(value)
+				if err != nil {
+					return fmt.Errorf("invalid %s '%s' (%s)", key, value, conf.Name())
+				}
+				*dst = append(*dst, network)
+			}
+		}
+	}
+	if conf.HasKey("key_types") {
+		policy.KeyTypes = c.readList(conf.Key("key_types"))
+	}
+	for key, dst := range map[string]*int{"min_rsa_bits": &policy.MinRsaBits, "max_rsa_bits": &policy.MaxRsaBits, "min_ecdsa_bits": &policy.MinEcdsaBits, "max_ecdsa_bits": &policy.MaxEcdsaBits} {
+		if conf.HasKey(key) {
+			if v, err := conf.Key(key).Int(); err == nil && v > 0 {
+				*dst = v
+			} else {
+				return fmt.Errorf("invalid %s '%s' (%s)", key, conf.Key(key).String(), conf.Name())
+			}
+		}
+	}
+	if conf.HasKey("required_subject") {
+		for _, value := range c.readList(conf.Key("required_subject")) {
+			if !inList(value, subjectFields) {
+				return fmt.Errorf("invalid required_subject '%s', expected one of %s (%s)", value, strings.Join(subjectFields, ", "), conf.Name())
+			}
+			policy.RequiredSubject = append(policy.RequiredSubject, value)
+		}
+	}
+	if conf.HasKey("copy_extensions") {
+		for _, value := range c.readList(conf.Key("copy_extensions")) {
+			oid, err := parseObjectIdentifier(value)
+			if err != nil {
+				return fmt.Errorf("invalid copy_extensions '%s' (%s)", value, conf.Name())
+			}
+			if name, ok := reservedExtensions[oid.String()]; ok {
+				return fmt.Errorf("invalid copy_extensions '%s', the %s extension is set by the CA (%s)", value, name, conf.Name())
+			}
+			policy.CopyExtensions = append(policy.CopyExtensions, oid)
+		}
+	}
+	if conf.HasKey("conflicts") {
+		value := strings.ToLower(conf.Key("conflicts").String())
+		if !IsConflictsMode(value) {
+			return fmt.Errorf("invalid conflicts '%s', expected one of %s, %s or %s (%s)", value, CONFLICTS_REJECT, CONFLICTS_RETURN, CONFLICTS_ALLOW, conf.Name())
+		}
+		policy.Conflicts = value
+	}
+	return nil
+}
+
+// IsConflictsMode checks if the value is one of the CONFLICTS_* modes
+func IsConflictsMode(value string) bool {
+	return inList(value, []string{CONFLICTS_REJECT, CONFLICTS_RETURN, CONFLICTS_ALLOW})
+}
+
+// readList returns the lowercase values of a comma separated list
+func (c *Config) readList(key *ini.Key) []string {
+	list := make([]string, 0)
+	for _, value := range key.Strings(",") {
+		if value != "" {
+			list = append(list, strings.ToLower(value))
+		}
+	}
+	return list
+}
+
+// parseNetwork parses a CIDR notation or single ip address
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address '%s'", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// parseObjectIdentifier parses a dotted object identifier like 1.2.3.4
+func parseObjectIdentifier(value string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid object identifier '%s'", value)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid object identifier '%s'", value)
+		}
+		oid[i] = v
+	}
+	return oid, nil
+}
+
+func inList(value string, list []string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// DEFAULT_PROFILE is the profile used when none is requested
+const DEFAULT_PROFILE string = "default"
+
+const (
+	SAN_TYPE_DNS   string = "dns"
+	SAN_TYPE_IP    string = "ip"
+	SAN_TYPE_EMAIL string = "email"
+	SAN_TYPE_URI   string = "uri"
+)
+
+var (
+	// the names of the key usages as used in the profile section
+	keyUsages = map[string]x509.KeyUsage{
+		"digital_signature":  x509.KeyUsageDigitalSignature,
+		"content_commitment": x509.KeyUsageContentCommitment,
+		"key_encipherment":   x509.KeyUsageKeyEncipherment,
+		"data_encipherment":  x509.KeyUsageDataEncipherment,
+		"key_agreement":      x509.KeyUsageKeyAgreement,
+		"encipher_only":      x509.KeyUsageEncipherOnly,
+		"decipher_only":      x509.KeyUsageDecipherOnly,
+	}
+	// the names of the extended key usages as used in the profile section
+	extKeyUsages = map[string]x509.ExtKeyUsage{
+		"any":              x509.ExtKeyUsageAny,
+		"server_auth":      x509.ExtKeyUsageServerAuth,
+		"client_auth":      x509.ExtKeyUsageClientAuth,
+		"code_signing":     x509.ExtKeyUsageCodeSigning,
+		"email_protection": x509.ExtKeyUsageEmailProtection,
+		"time_stamping":    x509.ExtKeyUsageTimeStamping,
+		"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+	}
+	sanTypes = []string{SAN_TYPE_DNS, SAN_TYPE_IP, SAN_TYPE_EMAIL, SAN_TYPE_URI}
+)
+
+// ProfileConfig describes the kind of certificate that is issued, like
+// a server, client or code signing certificate.
+type ProfileConfig struct {
+	Name        string
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	// the max validity as year, month and day, a zero
+	// value means no other limit than pem_not_after
+	MaxValidity [3]int
+	// the subject alternative name types that may be requested
+	SanTypes []string
+	// when true the basic constraints extension will be
+	// added to mark the certificate as a non CA certificate
+	BasicConstraints bool
+}
+
+// AllowsSanType checks if the given (SAN_TYPE_*) type may be requested
+func (p ProfileConfig) AllowsSanType(name string) bool {
+	for _, t := range p.SanTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultProfiles returns the profiles that are available without any
+// configuration, the default profile is the same as the certificates
+// that were issued before profiles existed.
+func defaultProfiles() []*ProfileConfig {
+	return []*ProfileConfig{
+		{
+			Name:        DEFAULT_PROFILE,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			SanTypes:    []string{SAN_TYPE_DNS, SAN_TYPE_IP, SAN_TYPE_EMAIL, SAN_TYPE_URI},
+		},
+		{
+			Name:             "server",
+			KeyUsage:         x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			SanTypes:         []string{SAN_TYPE_DNS, SAN_TYPE_IP},
+			BasicConstraints: true,
+		},
+		{
+			Name:             "client",
+			KeyUsage:         x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:      []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			SanTypes:         []string{SAN_TYPE_DNS, SAN_TYPE_IP, SAN_TYPE_EMAIL, SAN_TYPE_URI},
+			BasicConstraints: true,
+		},
+		{
+			Name:             "code_signing",
+			KeyUsage:         x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:      []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			SanTypes:         []string{SAN_TYPE_EMAIL, SAN_TYPE_URI},
+			BasicConstraints: true,
+		},
+		{
+			Name:             "smime",
+			KeyUsage:         x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:      []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			SanTypes:         []string{SAN_TYPE_EMAIL},
+			BasicConstraints: true,
+		},
+	}
+}
+
+// GetProfile will return the profile for the given name or nil when
+// not found, an empty name will return the default profile.
+func (c *Config) GetProfile(name string) *ProfileConfig {
+	if name == "" {
+		name = DEFAULT_PROFILE
+	}
+	profiles := c.Profiles
+	if profiles == nil {
+		// the config is not read (see Read) so only the built-in profiles
+		// are available, these are not saved because the config is shared
+		// between the requests and should not be changed after loading.
+		profiles = defaultProfiles()
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// readProfileSection will update (or create) the profile with the
+// values from the section, so a built-in profile can be modified by
+// only setting the values that should be changed.
+func (c *Config) readProfileSection(conf *ini.Section, name string) error {
+	if !validCaName.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s', only lowercase letters, digits, '-' and '_' are allowed", name)
+	}
+	profile := c.GetProfile(name)
+	if profile == nil {
+		profile = &ProfileConfig{Name: name, SanTypes: []string{SAN_TYPE_DNS, SAN_TYPE_IP}}
+		c.Profiles = append(c.Profiles, profile)
+	}
+	if conf.HasKey("key_usage") {
+		profile.KeyUsage = 0
+		for _, value := range conf.Key("key_usage").Strings(",") {
+			usage, ok := keyUsages[strings.ToLower(value)]
+			if !ok {
+				return fmt.Errorf("invalid key_usage '%s' (%s)", value, conf.Name())
+			}
+			profile.KeyUsage |= usage
+		}
+	}
+	if conf.HasKey("ext_key_usage") {
+		profile.ExtKeyUsage = make([]x509.ExtKeyUsage, 0)
+		for _, value := range conf.Key("ext_key_usage").Strings(",") {
+			usage, ok := extKeyUsages[strings.ToLower(value)]
+			if !ok {
+				return fmt.Errorf("invalid ext_key_usage '%s' (%s)", value, conf.Name())
+			}
+			profile.ExtKeyUsage = append(profile.ExtKeyUsage, usage)
+		}
+	}
+	if conf.HasKey("san_types") {
+		profile.SanTypes = make([]string, 0)
+		for _, value := range conf.Key("san_types").Strings(",") {
+			if !(ProfileConfig{SanTypes: sanTypes}).AllowsSanType(strings.ToLower(value)) {
+				return fmt.Errorf("invalid san_types '%s', expected one of %s (%s)", value, strings.Join(sanTypes, ", "), conf.Name())
+			}
+			profile.SanTypes = append(profile.SanTypes, strings.ToLower(value))
+		}
+	}
+	if conf.HasKey("max_validity") {
+		c.parseIntArray(conf.Key("max_validity").String(), &profile.MaxValidity)
+	}
+	if conf.HasKey("basic_constraints") {
+		if v, err := conf.Key("basic_constraints").Bool(); err == nil {
+			profile.BasicConstraints = v
+		} else {
+			return fmt.Errorf("invalid basic_constraints '%s' (%s)", conf.Key("basic_constraints").String(), conf.Name())
+		}
+	}
+	return nil
+}
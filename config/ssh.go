@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pbergman/caserver/util"
+	"gopkg.in/ini.v1"
+)
+
+// SshConfig holds the options of the ssh certificate authority that
+// signs the ssh public keys of users and hosts into certificates.
+type SshConfig struct {
+	// the ssh endpoints are disabled unless enabled in the config
+	Enabled bool
+	// the credentials for the http basic authentication that
+	// is required for signing, these are required when enabled.
+	Username string
+	Password string
+	// the principals (user names) that can be requested for user
+	// certificates, when empty no user certificates can be signed.
+	AllowedPrincipals []string
+	// the type of the ssh CA key that is created on first use
+	Key KeyConfig `default.ns:"ssh"`
+	// the validity of user and host certificates when none is requested
+	UserTtl time.Duration `default:"16h"`
+	HostTtl time.Duration `default:"720h"`
+	// the max validity that can be requested for a certificate
+	MaxTtl time.Duration `default:"2160h"`
+}
+
+func (c *Config) readSshSection(conf *ini.Section) error {
+	if conf.HasKey("enabled") {
+		if v, err := conf.Key("enabled").Bool(); err == nil {
+			c.Ssh.Enabled = v
+		} else {
+			return fmt.Errorf("invalid enabled '%s' (%s)", conf.Key("enabled").String(), conf.Name())
+		}
+	}
+	if conf.HasKey("username") {
+		c.Ssh.Username = conf.Key("username").String()
+	}
+	if conf.HasKey("password") {
+		c.Ssh.Password = conf.Key("password").String()
+	}
+	if c.Ssh.Enabled && (c.Ssh.Username == "" || c.Ssh.Password == "") {
+		return fmt.Errorf("the username and password are required when enabled (%s)", conf.Name())
+	}
+	if conf.HasKey("allowed_principals") {
+		c.Ssh.AllowedPrincipals = make([]string, 0)
+		for _, value := range conf.Key("allowed_principals").Strings(",") {
+			if value != "" {
+				c.Ssh.AllowedPrincipals = append(c.Ssh.AllowedPrincipals, value)
+			}
+		}
+	}
+	c.readKeySection(conf, &c.Ssh.Key)
+	for key, dst := range map[string]*time.Duration{"user_ttl": &c.Ssh.UserTtl, "host_ttl": &c.Ssh.HostTtl, "max_ttl": &c.Ssh.MaxTtl} {
+		if conf.HasKey(key) {
+			if v, err := util.ParseDuration(conf.Key(key).String()); err == nil && v > 0 {
+				*dst = v
+			} else {
+				return fmt.Errorf("invalid %s '%s' (%s)", key, conf.Key(key).String(), conf.Name())
+			}
+		}
+	}
+	if c.Ssh.UserTtl > c.Ssh.MaxTtl || c.Ssh.HostTtl > c.Ssh.MaxTtl {
+		return fmt.Errorf("the user_ttl and host_ttl should not exceed the max_ttl (%s)", conf.Name())
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pbergman/caserver/acme"
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// AcmeController exposes the (rfc8555) ACME server of every CA, the
+// resources are selected by the action and id of the request path.
+type AcmeController struct {
+	ApiCertController
+	servers map[string]*acme.Server
+}
+
+func (a AcmeController) Name() string {
+	return "controller.acme"
+}
+
+func NewAcme(registry *ca.Registry, conf *config.Config) *AcmeController {
+	controller := &AcmeController{
+		ApiCertController: newApiCertController(registry, `^/acme`+patternCa+`/(?P<action>directory|new-nonce|new-account|new-order|account|order|authz|cert)(?:/(?P<id>[a-f0-9]+)(?:/(?P<sub>orders|finalize|http-01|dns-01))?)?$`),
+		servers:           make(map[string]*acme.Server),
+	}
+	for _, name := range registry.Names() {
+		controller.servers[name] = acme.NewServer(registry.Get(name), conf, filepath.Join(conf.Path, "acme", name))
+	}
+	return controller
+}
+
+func (a AcmeController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	server := a.servers[manager.Name()]
+	vars := a.GetPathVars(req)
+
+	resp.Header().Set("Replay-Nonce", server.NewNonce())
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"index\"", server.Url("/directory")))
+
+	switch vars["action"] {
+	case "directory":
+		if req.Method != "GET" {
+			a.writeProblem(resp, acme.NewProblem(acme.ERROR_MALFORMED, http.StatusMethodNotAllowed, "method not allowed"), logger)
+			return
+		}
+		a.writeJson(resp, http.StatusOK, server.Directory())
+		return
+	case "new-nonce":
+		switch req.Method {
+		case "HEAD":
+			resp.WriteHeader(http.StatusOK)
+		case "GET":
+			resp.WriteHeader(http.StatusNoContent)
+		default:
+			a.writeProblem(resp, acme.NewProblem(acme.ERROR_MALFORMED, http.StatusMethodNotAllowed, "method not allowed"), logger)
+		}
+		return
+	}
+
+	if req.Method != "POST" {
+		a.writeProblem(resp, acme.NewProblem(acme.ERROR_MALFORMED, http.StatusMethodNotAllowed, "method not allowed"), logger)
+		return
+	}
+
+	if media, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); media != "application/jose+json" {
+		a.writeProblem(resp, acme.NewProblem(acme.ERROR_MALFORMED, http.StatusUnsupportedMediaType, "expected the content type application/jose+json"), logger)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+
+	if err != nil {
+		a.writeProblem(resp, err, logger)
+		return
+	}
+
+	path := "/" + vars["action"]
+
+	if vars["id"] != "" {
+		path += "/" + vars["id"]
+	}
+
+	if vars["sub"] != "" {
+		path += "/" + vars["sub"]
+	}
+
+	request, err := server.Verify(body, path)
+
+	if err != nil {
+		a.writeProblem(resp, err, logger)
+		return
+	}
+
+	if err := a.handle(server, request, vars, resp); err != nil {
+		a.writeProblem(resp, err, logger)
+	}
+}
+
+func (a AcmeController) handle(server *acme.Server, req *acme.Request, vars map[string]string, resp http.ResponseWriter) error {
+	id := vars["id"]
+	switch vars["action"] + "/" + vars["sub"] {
+	case "new-account/":
+		account, created, err := server.NewAccount(req)
+		if err != nil {
+			return err
+		}
+		resp.Header().Set("Location", server.AccountUrl(account.Id))
+		if created {
+			return a.writeJson(resp, http.StatusCreated, server.AccountView(account))
+		}
+		return a.writeJson(resp, http.StatusOK, server.AccountView(account))
+	case "account/":
+		account, err := server.UpdateAccount(req, id)
+		if err != nil {
+			return err
+		}
+		return a.writeJson(resp, http.StatusOK, server.AccountView(account))
+	case "account/orders":
+		orders, err := server.AccountOrders(req, id)
+		if err != nil {
+			return err
+		}
+		return a.writeJson(resp, http.StatusOK, map[string][]string{"orders": orders})
+	case "new-order/":
+		order, err := server.NewOrder(req)
+		if err != nil {
+			return err
+		}
+		resp.Header().Set("Location", server.OrderUrl(order.Id))
+		return a.writeJson(resp, http.StatusCreated, server.OrderView(order))
+	case "order/":
+		order, err := server.GetOrder(req, id)
+		if err != nil {
+			return err
+		}
+		return a.writeJson(resp, http.StatusOK, server.OrderView(order))
+	case "order/finalize":
+		order, err := server.Finalize(req, id)
+		if err != nil {
+			return err
+		}
+		resp.Header().Set("Location", server.OrderUrl(order.Id))
+		return a.writeJson(resp, http.StatusOK, server.OrderView(order))
+	case "authz/":
+		authz, err := server.GetAuthorization(req, id)
+		if err != nil {
+			return err
+		}
+		return a.writeJson(resp, http.StatusOK, server.AuthorizationView(authz))
+	case "authz/http-01", "authz/dns-01":
+		challenge, authz, err := server.RespondChallenge(req, id, vars["sub"])
+		if err != nil {
+			return err
+		}
+		resp.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"up\"", server.AuthorizationUrl(authz.Id)))
+		return a.writeJson(resp, http.StatusOK, server.ChallengeView(authz, challenge))
+	case "cert/":
+		record, err := server.GetCertificate(req, id)
+		if err != nil {
+			return err
+		}
+		buf := new(bytes.Buffer)
+		if err := server.WriteCertificate(buf, record); err != nil {
+			return err
+		}
+		resp.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, err = buf.WriteTo(resp)
+		return err
+	default:
+		return acme.NewProblem(acme.ERROR_MALFORMED, http.StatusNotFound, "not found")
+	}
+}
+
+func (a AcmeController) writeJson(resp http.ResponseWriter, code int, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	_, err = resp.Write(raw)
+	return err
+}
+
+// writeProblem will write the error as (rfc7807) problem document
+func (a AcmeController) writeProblem(resp http.ResponseWriter, err error, logger logger.LoggerInterface) {
+	problem := acme.ToProblem(err)
+	logger.Error(problem.Error())
+	raw, _ := json.Marshal(problem)
+	resp.Header().Set("Content-Type", "application/problem+json")
+	resp.WriteHeader(problem.Status)
+	resp.Write(raw)
+}
@@ -10,12 +10,20 @@ import (
 )
 
 type ApiCaController struct {
-	manager *ca.Manager
+	Controller
+	registry *ca.Registry
 }
 
 func (s ApiCaController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
 
-	record := s.manager.Get(s.manager.GetCa())
+	manager := s.registry.Get(s.GetPathVar("ca", req))
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	record := manager.Get(manager.GetCa())
 
 	if req.Method != "GET" {
 		write_error(resp, fmt.Sprintf("Method %s is not supported.", req.Method), http.StatusMethodNotAllowed, logger)
@@ -40,11 +48,12 @@ func (s ApiCaController) Name() string {
 }
 
 func (s ApiCaController) Match(req *router.Request) bool {
-	return req.URL.Path == "/api/v1/ca" && req.Method == "GET"
+	return s.Controller.Match(req) && req.Method == "GET"
 }
 
-func NewApiCa(manager *ca.Manager) *ApiCaController {
+func NewApiCa(registry *ca.Registry) *ApiCaController {
 	return &ApiCaController{
-		manager: manager,
+		Controller: newController(`^/api/v1/ca` + patternCa + `$`),
+		registry:   registry,
 	}
 }
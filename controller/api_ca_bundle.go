@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// ApiCaBundleController will serve the active root and the retiring
+// roots (that are not expired) so trust stores can be updated before
+// the retiring root expires.
+type ApiCaBundleController struct {
+	ApiCertController
+}
+
+func (a ApiCaBundleController) Name() string {
+	return "controller.api.ca.bundle"
+}
+
+func (a ApiCaBundleController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "GET"
+}
+
+func NewApiCaBundle(registry *ca.Registry) *ApiCaBundleController {
+	return &ApiCaBundleController{newApiCertController(registry, `^/api/v1/ca`+patternCa+`/bundle$`)}
+}
+
+func (a ApiCaBundleController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	roots := manager.GetRoots()
+
+	if len(roots) == 0 {
+		write_error(resp, "Failed to find CA.", http.StatusInternalServerError, logger)
+		return
+	}
+
+	// remove from records so wo`t be printed.
+	for _, root := range roots {
+		root.SetPrivateKey(nil)
+	}
+
+	if err := WriteResponse(req, resp, roots[1:], roots[0]); err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+	}
+}
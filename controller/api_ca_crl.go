@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// ApiCaCrlController serves the CRL of the issuer with the given subject key
+// id or the CRL of the active issuer when no subject key id is given.
+type ApiCaCrlController struct {
+	ApiCertController
+}
+
+func (a ApiCaCrlController) Name() string {
+	return "controller.api.ca.crl"
+}
+
+func (a ApiCaCrlController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "GET"
+}
+
+func NewApiCaCrl(registry *ca.Registry) *ApiCaCrlController {
+	return &ApiCaCrlController{newApiCertController(registry, `^/api/v1/ca`+patternCa+`/crl(?:/(?P<kid>[a-f0-9]+))?$`)}
+}
+
+func (a ApiCaCrlController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	crl, err := manager.GetCRL(a.GetPathVar("kid", req))
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusNotFound, logger)
+		return
+	}
+
+	if err := WriteCrlResponse(req, resp, crl, manager.Name()); err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+	}
+}
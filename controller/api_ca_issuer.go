@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// ApiCaIssuerController will serve the DER encoded CA certificate with the
+// given subject key id as used in the authority information access extension.
+type ApiCaIssuerController struct {
+	ApiCertController
+}
+
+func (a ApiCaIssuerController) Name() string {
+	return "controller.api.ca.issuer"
+}
+
+func (a ApiCaIssuerController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "GET"
+}
+
+func NewApiCaIssuer(registry *ca.Registry) *ApiCaIssuerController {
+	return &ApiCaIssuerController{newApiCertController(registry, `^/api/v1/ca`+patternCa+`/issuer/(?P<kid>[a-f0-9]+)(?:\.(?:der|cer|crt))?$`)}
+}
+
+func (a ApiCaIssuerController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	record := manager.GetCaBySubjectKeyId(a.GetPathVar("kid", req))
+
+	if record == nil {
+		write_error(resp, "Failed to find CA.", http.StatusNotFound, logger)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/pkix-cert")
+	resp.Write(record.GetCertificate().Raw)
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// ApiCaOcspController is a (rfc6960) OCSP responder that supports
+// the GET and POST request (see appendix A.1 of the rfc).
+type ApiCaOcspController struct {
+	ApiCertController
+}
+
+func (a ApiCaOcspController) Name() string {
+	return "controller.api.ca.ocsp"
+}
+
+func (a ApiCaOcspController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && (request.Method == "GET" || request.Method == "POST")
+}
+
+func NewApiCaOcsp(registry *ca.Registry) *ApiCaOcspController {
+	return &ApiCaOcspController{newApiCertController(registry, `^/api/v1/ca`+patternCa+`/ocsp(?:/(?P<request>.+))?$`)}
+}
+
+func (a ApiCaOcspController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	var raw []byte
+	var err error
+
+	switch req.Method {
+	case "GET":
+		raw, err = base64.StdEncoding.DecodeString(a.GetPathVar("request", req))
+	case "POST":
+		raw, err = ioutil.ReadAll(io.LimitReader(req.Body, 1<<16))
+	}
+
+	if err != nil || len(raw) == 0 {
+		write_error(resp, "invalid OCSP request", http.StatusBadRequest, logger)
+		return
+	}
+
+	response, err := manager.NewOCSPResponse(raw)
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/ocsp-response")
+	resp.Write(response)
+}
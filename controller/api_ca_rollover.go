@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+// ApiCaRolloverController will replace the CA with a new CA that is
+// cross signed by the current CA, see ca.Manager.Rollover.
+type ApiCaRolloverController struct {
+	ApiCertController
+}
+
+func (a ApiCaRolloverController) Name() string {
+	return "controller.api.ca.rollover"
+}
+
+func (a ApiCaRolloverController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "POST"
+}
+
+func NewApiCaRollover(registry *ca.Registry) *ApiCaRolloverController {
+	return &ApiCaRolloverController{newApiCertController(registry, `^/api/v1/ca`+patternCa+`/rollover$`)}
+}
+
+func (a ApiCaRolloverController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	record, err := manager.Rollover()
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		return
+	}
+
+	logger.Notice("rollover of CA '" + manager.Name() + "' to " + record.GetId().String())
+
+	// remove from record so wo`t be printed.
+	record.SetPrivateKey(nil)
+
+	if err := WriteResponse(req, resp, nil, record); err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+	}
+}
@@ -1,22 +1,76 @@
 package controller
 
 import (
+	"fmt"
+	"net/url"
+	"time"
+
 	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/router"
 	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
 )
 
+// the optional path segment that selects the CA by name
+const patternCa = `(?:/(?P<ca>[a-z0-9_-]+))?`
+
 type ApiCertController struct {
 	Controller
-	manager *ca.Manager
+	registry *ca.Registry
+}
+
+// getManager will return the manager of the CA selected in the
+// request path or the default when no CA was given.
+func (a ApiCertController) getManager(req *router.Request) *ca.Manager {
+	return a.registry.Get(a.GetPathVar("ca", req))
 }
 
-func (a ApiCertController) getCa() storage.Record {
-	return a.manager.Get(a.manager.GetCa())
+func (a ApiCertController) getIssuer(manager *ca.Manager) storage.Record {
+	return manager.Get(manager.GetIssuer())
 }
 
-func newApiCertController(manager *ca.Manager, pattern string) ApiCertController {
+// getValidity will parse the not_before, not_after and ttl parameters
+// of the request and returns nil when none of them were given.
+func (a ApiCertController) getValidity(form url.Values) (*ca.Validity, error) {
+	var validity ca.Validity
+	var set bool
+	for name, dst := range map[string]*time.Time{"not_before": &validity.NotBefore, "not_after": &validity.NotAfter} {
+		if value := form.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid '%s', expected a RFC3339 formatted time", name)
+			}
+			*dst, set = t, true
+		}
+	}
+	if value := form.Get("ttl"); value != "" {
+		ttl, err := util.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'ttl', expected a duration like 12h or 30d")
+		}
+		validity.TTL, set = ttl, true
+	}
+	if !set {
+		return nil, nil
+	}
+	if err := validity.Validate(); err != nil {
+		return nil, err
+	}
+	return &validity, nil
+}
+
+func newApiCertController(registry *ca.Registry, pattern string) ApiCertController {
 	return ApiCertController{
-		manager:    manager,
+		registry:   registry,
 		Controller: newController(pattern),
 	}
 }
+
+// recordPath returns the api path of the given record
+func recordPath(manager *ca.Manager, record storage.Record) string {
+	if manager.Name() == config.DEFAULT_CA {
+		return "/api/v1/cert/" + record.GetId().String()
+	}
+	return "/api/v1/" + manager.Name() + "/cert/" + record.GetId().String()
+}
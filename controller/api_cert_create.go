@@ -4,13 +4,17 @@ import (
 	"crypto/x509/pkix"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
 	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/caserver/storage"
 	"github.com/pbergman/logger"
 )
 
@@ -26,13 +30,20 @@ func (a ApiCertCreateController) Match(request *router.Request) bool {
 	return a.Controller.Match(request) && request.Method == "POST"
 }
 
-func NewApiCertCreate(manager *ca.Manager) *ApiCertCreateController {
-	return &ApiCertCreateController{newApiCertController(manager, `^(?i)/api/v1/cert$`)}
+func NewApiCertCreate(registry *ca.Registry) *ApiCertCreateController {
+	return &ApiCertCreateController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert$`)}
 }
 
 func (a ApiCertCreateController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
 
-	record := a.getCa()
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	record := a.getIssuer(manager)
 
 	if record == nil {
 		write_error(resp, "Failed to find CA.", http.StatusInternalServerError, logger)
@@ -51,48 +62,185 @@ func (a ApiCertCreateController) Handle(req *router.Request, resp http.ResponseW
 		return
 	}
 
-	if r := a.manager.Search(subject.CommonName); r != nil {
-		resp.Header().Set("link", fmt.Sprintf("href=\"/api/v1/cert/%s\", rel=\"record\"", r.GetId().String()))
-		write_error(resp, fmt.Sprintf("a csr exists for %s", subject.CommonName), http.StatusBadRequest, logger)
+	profile := manager.GetProfile(req.Form.Get("profile"))
+
+	if profile == nil {
+		write_error(resp, fmt.Sprintf("unknown profile '%s'", req.Form.Get("profile")), http.StatusBadRequest, logger)
+		return
+	}
+
+	validity, err := a.getValidity(req.Form)
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	emails, uris, err := a.getAltNames(req.Form)
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
 		return
 	}
 
 	var hosts []string
 
+	// the common name is used as host when no alternative names are given
 	if value, ok := req.Form["host"]; ok {
 		hosts = value
-	} else {
+	} else if len(emails) == 0 && len(uris) == 0 && profile.AllowsSanType(config.SAN_TYPE_DNS) {
 		hosts = []string{subject.CommonName}
 	}
 
-	entry, err := a.manager.NewCertificateRequest(hosts, subject, a.getBits(req))
+	dns, ips := ca.SplitHosts(hosts)
+
+	if err := ca.CheckProfile(profile, dns, ips, emails, uris); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	options := a.getKeyOptions(req)
+
+	// the policy is checked first so an existing certificate is
+	// only returned for a request that complies to the policy
+	if err := manager.GetPolicy().CheckNewRequest(subject, hosts, emails, uris, options); err != nil {
+		write_error(resp, err.Error(), http.StatusForbidden, logger)
+		return
+	}
+
+	if err := manager.CheckNameConstraints(record, dns, ips, emails); err != nil {
+		write_error(resp, err.Error(), http.StatusForbidden, logger)
+		return
+	}
+
+	mode, err := a.getConflictsMode(req, manager)
 
 	if err != nil {
-		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	if conflicts := manager.Conflicts(dns, ips, emails, uris); len(conflicts) > 0 && mode != config.CONFLICTS_ALLOW {
+		if existing := findExisting(conflicts, subject, dns, ips, emails, uris); existing != nil && mode == config.CONFLICTS_RETURN {
+			resp.Header().Set("Location", recordPath(manager, existing))
+			if err := WriteResponse(req, resp, manager.GetChain(existing), existing); err != nil {
+				write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+			}
+			return
+		}
+		a.writeConflicts(resp, manager, conflicts, logger)
 		return
 	}
 
-	if err := a.manager.SignCertificateRequest(entry, record); err != nil {
+	entry, err := manager.NewCertificateRequest(hosts, emails, uris, subject, options)
+
+	if err != nil {
 		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 		return
 	}
 
-	if err := WriteResponse(req, resp, record, entry); err != nil {
+	if err := manager.SignCertificateRequest(entry, record, profile, validity); err != nil {
+		// the key and request are already persisted so the
+		// entry is removed to not leave an unusable record
+		if err := manager.Remove(entry.GetId()); err != nil {
+			logger.Error(fmt.Sprintf("failed to remove record %s: %s", entry.GetId(), err))
+		}
+		write_error(resp, err.Error(), error_code(err, http.StatusInternalServerError), logger)
+		return
+	}
+
+	resp.Header().Set("Location", recordPath(manager, entry))
+
+	if err := WriteResponse(req, newStatusResponseWriter(resp, http.StatusCreated), manager.GetChain(entry), entry); err != nil {
 		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 		return
 	}
 }
 
-func (a ApiCertCreateController) getBits(req *router.Request) int {
-	var bits int = 2048
+// getConflictsMode returns the (config.CONFLICTS_*) mode from the if_exists parameter
+// or the policy, overlapping certificates can only be allowed by the policy.
+func (a ApiCertCreateController) getConflictsMode(req *router.Request, manager *ca.Manager) (string, error) {
+	mode := manager.GetPolicy().GetConflicts()
+	if value := req.Form.Get("if_exists"); value != "" {
+		if !config.IsConflictsMode(value) {
+			return "", fmt.Errorf("invalid value for 'if_exists', expected %s, %s or %s", config.CONFLICTS_REJECT, config.CONFLICTS_RETURN, config.CONFLICTS_ALLOW)
+		}
+		if value == config.CONFLICTS_ALLOW && mode != config.CONFLICTS_ALLOW {
+			return "", errors.New("overlapping certificates are not allowed by the policy")
+		}
+		mode = value
+	}
+	return mode, nil
+}
+
+// findExisting returns the record that has the same subject and
+// alternative names as requested when it is one of the conflicts.
+func findExisting(conflicts []storage.Record, subject pkix.Name, dns []string, ips []net.IP, emails []string, uris []*url.URL) storage.Record {
+	requested := strings.Join(sanList(dns, ips, emails, uris), ",")
+	for _, record := range conflicts {
+		cert := record.GetCertificate()
+		name := subject
+		// the serial number is added to the subject when issued (see factory.checkSubject)
+		if name.SerialNumber == "" {
+			name.SerialNumber = cert.Subject.SerialNumber
+		}
+		if cert.Subject.String() == name.String() && strings.Join(sanList(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs), ",") == requested {
+			return record
+		}
+	}
+	return nil
+}
+
+func (a ApiCertCreateController) getKeyOptions(req *router.Request) ca.KeyOptions {
+	var options = ca.KeyOptions{Type: ca.KEY_TYPE_RSA, Bits: 2048}
 
 	if val, ok := req.Form["bits"]; ok {
 		if v, err := strconv.Atoi(val[0]); err == nil {
-			bits = v
+			options.Bits = v
 		}
 	}
 
-	return bits
+	if val, ok := req.Form["key_type"]; ok {
+		options.Type = val[0]
+	}
+
+	if val, ok := req.Form["curve"]; ok {
+		options.Curve = val[0]
+	}
+
+	return options
+}
+
+// writeConflicts will write a conflict response with a line and link header for
+// every existing certificate that has alternative names that overlap.
+func (a ApiCertCreateController) writeConflicts(resp http.ResponseWriter, manager *ca.Manager, conflicts []storage.Record, logger logger.LoggerInterface) {
+	lines := make([]string, 0, len(conflicts))
+	for _, record := range conflicts {
+		cert := record.GetCertificate()
+		path := recordPath(manager, record)
+		resp.Header().Add("link", fmt.Sprintf("href=\"%s\", rel=\"record\"", path))
+		lines = append(lines, fmt.Sprintf("%s %s (%s)", path, cert.Subject.CommonName, strings.Join(sanList(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs), ", ")))
+	}
+	write_error(resp, "the alternative names overlap with existing certificates:\n"+strings.Join(lines, "\n"), http.StatusConflict, logger)
+}
+
+// getAltNames returns the email and uri (like spiffe://example.com/service)
+// subject alternative names from the email and uri parameters.
+func (a ApiCertCreateController) getAltNames(v url.Values) (emails []string, uris []*url.URL, err error) {
+	for _, value := range v["email"] {
+		if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+			return nil, nil, fmt.Errorf("invalid email address '%s'", value)
+		}
+		emails = append(emails, value)
+	}
+	for _, value := range v["uri"] {
+		uri, err := url.Parse(value)
+		if err != nil || uri.Scheme == "" {
+			return nil, nil, fmt.Errorf("invalid uri '%s', expected an absolute uri", value)
+		}
+		uris = append(uris, uri)
+	}
+	return
 }
 
 func (a ApiCertCreateController) getSubject(v url.Values) (name pkix.Name, err error) {
@@ -0,0 +1,62 @@
+package controller_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/util"
+)
+
+func TestApiCertCreate_IfExists(t *testing.T) {
+	policy := new(config.PolicyConfig)
+	util.SetDefaults(policy)
+	server, _ := newTestServer(t, func(conf *config.Config) {
+		conf.Policies = []*config.PolicyConfig{policy}
+	})
+	values := url.Values{"cn": {"example.com"}, "host": {"example.com", "www.example.com"}, "key_type": {"ecdsa"}}
+	location := createCertificate(t, server, values)
+
+	for mode, status := range map[string]int{"return": http.StatusOK, "reject": http.StatusConflict, "": http.StatusConflict} {
+		values.Set("if_exists", mode)
+		resp, err := http.PostForm(server.URL+"/api/v1/cert", values)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Fatalf("expected status %d for if_exists '%s' got %d", status, mode, resp.StatusCode)
+		}
+
+		if mode == "return" && resp.Header.Get("Location") != location {
+			t.Fatalf("expected the location of the existing record %s got %s", location, resp.Header.Get("Location"))
+		}
+	}
+
+	// a new certificate when the names are not the same
+	values.Set("if_exists", "return")
+	values["host"] = []string{"api.example.com"}
+
+	if other := createCertificate(t, server, values); other == location {
+		t.Fatal("expected a new record for other alternative names")
+	}
+
+	// the existing certificate is not returned when the request violates the policy
+	policy.DeniedDomains = []string{"www.example.com"}
+	values["host"] = []string{"example.com", "www.example.com"}
+	resp, err := http.PostForm(server.URL+"/api/v1/cert", values)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("Location") != "" {
+		t.Fatalf("expected status 403 without the existing record got %d", resp.StatusCode)
+	}
+}
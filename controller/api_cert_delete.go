@@ -20,16 +20,26 @@ func (a ApiCertDeleteController) Match(request *router.Request) bool {
 	return a.Controller.Match(request) && request.Method == "DELETE"
 }
 
-func NewApiCertDelete(manager *ca.Manager) *ApiCertDeleteController {
-	return &ApiCertDeleteController{newApiCertController(manager, `^(?i)/api/v1/cert/(?P<id>[a-f0-9]{40})$`)}
+func NewApiCertDelete(registry *ca.Registry) *ApiCertDeleteController {
+	return &ApiCertDeleteController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert/(?P<id>[a-f0-9]{40})$`)}
 }
 
 func (a ApiCertDeleteController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
 	id := a.GetPathVar("id", req)
-	if record := a.manager.Lookup(id); record == nil {
+	manager := a.getManager(req)
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+	if record := manager.Lookup(id); record == nil {
 		write_error(resp, "No record found for '"+id+"' .", http.StatusNotFound, logger)
+	} else if record.IsCa() || record.IsSshCa() {
+		write_error(resp, "The record of a certificate authority can not be removed.", http.StatusForbidden, logger)
+	} else if record.IsRevoked() {
+		// the revoked record is needed for the CRL and OCSP responses
+		write_error(resp, "A revoked record can not be removed.", http.StatusForbidden, logger)
 	} else {
-		if err := a.manager.Remove(record.GetId()); err != nil {
+		if err := manager.Remove(record.GetId()); err != nil {
 			write_error(resp, err.Error(), http.StatusNotFound, logger)
 		} else {
 			resp.WriteHeader(http.StatusAccepted)
@@ -20,20 +20,29 @@ func (a ApiCertGetController) Match(request *router.Request) bool {
 	return a.Controller.Match(request) && request.Method == "GET"
 }
 
-func NewApiCertGet(manager *ca.Manager) *ApiCertGetController {
-	return &ApiCertGetController{newApiCertController(manager, `^(?i)/api/v1/cert/(?P<id>[a-f0-9]{4,})$`)}
+func NewApiCertGet(registry *ca.Registry) *ApiCertGetController {
+	return &ApiCertGetController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert/(?P<id>[a-f0-9]{4,})$`)}
 }
 
 func (a ApiCertGetController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
 	id := a.GetPathVar("id", req)
-	if entry := a.manager.Lookup(id); entry == nil {
+	manager := a.getManager(req)
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+	if entry := manager.Lookup(id); entry == nil {
 		write_error(resp, "could not find any record by "+id, http.StatusNotFound, logger)
 		return
 	} else {
 		if entry.GetCertificate() == nil {
 			write_error(resp, "no certificate found for record "+id, http.StatusNotFound, logger)
 		} else {
-			if err := WriteResponse(req, resp, a.getCa(), entry); err != nil {
+			// the key of a CA is never printed, see ApiCaController
+			if entry.IsCa() {
+				entry.SetPrivateKey(nil)
+			}
+			if err := WriteResponse(req, resp, manager.GetChain(entry), entry); err != nil {
 				write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 			}
 		}
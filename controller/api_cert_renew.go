@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+type ApiCertRenewController struct {
+	ApiCertController
+}
+
+func (a ApiCertRenewController) Name() string {
+	return "controller.api.cert.renew"
+}
+
+func (a ApiCertRenewController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "POST"
+}
+
+func NewApiCertRenew(registry *ca.Registry) *ApiCertRenewController {
+	return &ApiCertRenewController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert/(?P<id>[a-f0-9]{4,})/renew$`)}
+}
+
+func (a ApiCertRenewController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	id := a.GetPathVar("id", req)
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	var rekey bool
+
+	if value := req.Form.Get("rekey"); value != "" {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			write_error(resp, "invalid value for 'rekey', expected a boolean", http.StatusBadRequest, logger)
+			return
+		}
+		rekey = v
+	}
+
+	record := manager.Lookup(id)
+
+	if record == nil || !record.HasCertificate() {
+		write_error(resp, "No record found for '"+id+"' .", http.StatusNotFound, logger)
+		return
+	}
+
+	renewal, err := manager.Renew(record, rekey)
+
+	if err != nil {
+		write_error(resp, err.Error(), error_code(err, http.StatusBadRequest), logger)
+		return
+	}
+
+	resp.Header().Set("Location", recordPath(manager, renewal))
+	resp.Header().Set("link", fmt.Sprintf("href=\"%s\", rel=\"predecessor\"", recordPath(manager, record)))
+
+	if err := WriteResponse(req, resp, manager.GetChain(renewal), renewal); err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		return
+	}
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+)
+
+type ApiCertRevokeController struct {
+	ApiCertController
+}
+
+func (a ApiCertRevokeController) Name() string {
+	return "controller.api.cert.revoke"
+}
+
+func (a ApiCertRevokeController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "POST"
+}
+
+func NewApiCertRevoke(registry *ca.Registry) *ApiCertRevokeController {
+	return &ApiCertRevokeController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert/(?P<id>[a-f0-9]{4,})/revoke$`)}
+}
+
+func (a ApiCertRevokeController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	id := a.GetPathVar("id", req)
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	reason, err := ca.ParseRevocationReason(req.Form.Get("reason"))
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	record := manager.Lookup(id)
+
+	if record == nil || !record.HasCertificate() {
+		write_error(resp, "No record found for '"+id+"' .", http.StatusNotFound, logger)
+		return
+	}
+
+	if record.IsRevoked() {
+		write_error(resp, "Certificate of record '"+id+"' is already revoked.", http.StatusConflict, logger)
+		return
+	}
+
+	if err := manager.Revoke(record, reason); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	resp.Header().Set("Location", recordPath(manager, record))
+	resp.WriteHeader(http.StatusAccepted)
+}
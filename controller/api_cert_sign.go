@@ -24,11 +24,18 @@ func (a ApiCertSignController) Match(request *router.Request) bool {
 	return a.Controller.Match(request) && request.Method == "PUT"
 }
 
-func NewApiCertSign(manager *ca.Manager) *ApiCertSignController {
-	return &ApiCertSignController{newApiCertController(manager, `^(?i)/api/v1/cert$`)}
+func NewApiCertSign(registry *ca.Registry) *ApiCertSignController {
+	return &ApiCertSignController{newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/cert$`)}
 }
 
 func (a ApiCertSignController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
 	file, _, err := req.FormFile("csr")
 
 	if err != nil {
@@ -45,37 +52,69 @@ func (a ApiCertSignController) Handle(req *router.Request, resp http.ResponseWri
 
 	if err != nil {
 		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		return
 	}
 
 	if blockCsr == nil {
 		write_error(resp, "uploaded file was not a PEM encoded block.", http.StatusBadRequest, logger)
+		return
 	}
 
 	if blockCsr.Type != storage.BLOCK_TYPE_CSR {
 		write_error(resp, "invalid PEM type", http.StatusBadRequest, logger)
+		return
 	}
 
-	caRecord := a.getCa()
+	caRecord := a.getIssuer(manager)
 	csr, err := x509.ParseCertificateRequest(blockCsr.Bytes)
 
 	if err != nil {
 		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
 	}
 
 	if csr.Subject.CommonName == "" {
 		write_error(resp, "missing required 'cn' field in csr", http.StatusBadRequest, logger)
+		return
 	}
 
-	cer, err := a.manager.GetFactory().NewCertificate(csr, caRecord.GetCertificate(), caRecord.GetPrivateKey())
+	profile := manager.GetProfile(req.FormValue("profile"))
+
+	if profile == nil {
+		write_error(resp, "unknown profile '"+req.FormValue("profile")+"'", http.StatusBadRequest, logger)
+		return
+	}
+
+	validity, err := a.getValidity(req.Form)
 
 	if err != nil {
-		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
 	}
 
-	cerRecord := a.manager.NewRecord()
-	cerRecord.SetCertificate(cer)
+	// the record is stored with the request and certificate (without
+	// key) so it can be listed, renewed and revoked as created ones.
+	cerRecord := manager.NewRecord()
+	cerRecord.SetCertificateRequest(csr)
+
+	// the profile, policy and name constraints are checked when signed
+	if err := manager.SignCertificateRequest(cerRecord, caRecord, profile, validity); err != nil {
+		write_error(resp, err.Error(), error_code(err, http.StatusInternalServerError), logger)
+		return
+	}
+
+	resp.Header().Set("Location", recordPath(manager, cerRecord))
+
+	// the client has the request so only the certificate is returned
+	response := manager.NewRecord()
+	response.SetCertificate(cerRecord.GetCertificate())
 
-	if err := WriteResponse(req, resp, caRecord, cerRecord); err != nil {
+	if err := WriteResponse(req, resp, manager.GetChain(cerRecord), response); err != nil {
 		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 	}
 }
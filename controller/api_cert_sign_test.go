@@ -0,0 +1,111 @@
+package controller_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/util"
+)
+
+// signRequest will upload a new certificate request for the given common name and hosts
+func signRequest(t *testing.T, server *httptest.Server, cn string, hosts ...string) *http.Response {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}, DNSNames: hosts}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("csr", "example.csr")
+	pem.Encode(part, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: raw})
+	writer.Close()
+	req, _ := http.NewRequest("PUT", server.URL+"/api/v1/cert", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// listCerts returns the records of the certificate listing by id
+func listCerts(t *testing.T, server *httptest.Server) map[string]map[string]interface{} {
+	resp, err := http.Get(server.URL + "/api/v1/list/cert.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	list := make(map[string]map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestApiCertSign(t *testing.T) {
+	server, manager := newTestServer(t, nil)
+	resp := signRequest(t, server, "example.com", "example.com")
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", resp.StatusCode)
+	}
+
+	location := resp.Header.Get("Location")
+	id := strings.TrimPrefix(location, "/api/v1/cert/")
+	record := manager.Lookup(id)
+
+	if record == nil || !record.HasCertificate() || !record.HasCertificateRequest() || record.HasPrivateKey() {
+		t.Fatalf("expected a record with certificate and request but without key at %s", location)
+	}
+
+	if _, ok := listCerts(t, server)[id]; !ok {
+		t.Fatal("expected the signed certificate in the listing")
+	}
+
+	if resp, err := http.Post(server.URL+location+"/renew", "", nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the signed certificate to be renewed: %v", err)
+	} else if renewal := manager.Lookup(strings.TrimPrefix(resp.Header.Get("Location"), "/api/v1/cert/")); renewal == nil || renewal.GetPredecessor().String() != id {
+		t.Fatal("expected the renewal to reference the signed certificate")
+	}
+
+	if resp, err := http.Post(server.URL+location+"/revoke", "", nil); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the signed certificate to be revoked: %v", err)
+	}
+
+	if item := listCerts(t, server)[id]; item == nil || item["revocation"] == nil {
+		t.Fatal("expected the revocation of the signed certificate in the listing")
+	}
+}
+
+func TestApiCertSign_Policy(t *testing.T) {
+	server, _ := newTestServer(t, func(conf *config.Config) {
+		policy := new(config.PolicyConfig)
+		util.SetDefaults(policy)
+		policy.DeniedDomains = []string{"*.denied.com"}
+		conf.Policies = []*config.PolicyConfig{policy}
+	})
+
+	for host, status := range map[string]int{"host.denied.com": http.StatusForbidden, "host.example.com": http.StatusOK} {
+		resp := signRequest(t, server, host, host)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Fatalf("expected status %d for %s got %d: %s", status, host, resp.StatusCode, body)
+		}
+	}
+}
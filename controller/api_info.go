@@ -8,27 +8,55 @@ import (
 	"net"
 	"net/http"
 	"text/tabwriter"
+	"time"
 
 	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
 	"github.com/pbergman/caserver/router"
 	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
 	"github.com/pbergman/logger"
 )
 
+// listItem holds the certificate, request, revocation etc. of a record
+type listItem struct {
+	id    string
+	items []interface{}
+}
+
 type ApiListController struct {
 	ApiCertController
+	// the default period for the expiring list
+	within time.Duration
 }
 
 func (a ApiListController) Name() string {
 	return "controller.api.list"
 }
 
-func NewApiList(manager *ca.Manager) *ApiListController {
-	return &ApiListController{newApiCertController(manager, `^(?i)/api/v1/list(?:/(?P<path>ca|cert|csr))?$`)}
+func NewApiList(registry *ca.Registry, conf *config.Config) *ApiListController {
+	return &ApiListController{
+		ApiCertController: newApiCertController(registry, `^(?i)/api/v1`+patternCa+`/list(?:/(?P<path>ca|cert|csr|expiring))?$`),
+		within:            conf.ExpiryWarning,
+	}
 }
 
 func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
-	certs, err := a.getCerts(req)
+	manager := a.getManager(req)
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+	within := a.within
+	if value := req.URL.Query().Get("within"); value != "" {
+		v, err := util.ParseDuration(value)
+		if err != nil || v < 0 {
+			write_error(resp, "invalid value for 'within', expected a duration like 30d or 12h", http.StatusBadRequest, logger)
+			return
+		}
+		within = v
+	}
+	certs, err := a.getCerts(req, manager, within)
 	if err != nil {
 		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 		return
@@ -36,7 +64,8 @@ func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter,
 	switch req.GetAcceptResponseType().MatchFor(router.ContentTypeText | router.ContentTypeJson) {
 	case router.ContentTypeText:
 		writer := tabwriter.NewWriter(resp, 0, 0, 3, ' ', 0)
-		for k, v := range certs {
+		for _, cert := range certs {
+			k, v := cert.id, cert.items
 			for c, i := len(v), 0; i < c; i++ {
 				switch t := v[i].(type) {
 				case *x509.CertificateRequest:
@@ -48,19 +77,40 @@ func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter,
 				case *x509.Certificate:
 					writer.Write([]byte("[CERTIFICATE]\t\n"))
 					writer.Write([]byte(" id\t" + k + "\n"))
+					writer.Write([]byte(" serial\t" + t.SerialNumber.Text(16) + "\n"))
+					writer.Write([]byte(" not before\t" + t.NotBefore.Format(time.RFC3339) + "\n"))
+					writer.Write([]byte(" not after\t" + t.NotAfter.Format(time.RFC3339) + "\n"))
 					a.writeMergeList(writer, " hosts", a.mergeHosts(t.DNSNames, t.IPAddresses))
 					a.writeTextName(writer, t.Subject, "SUBJECT")
 					if !t.IsCA {
 						a.writeTextName(writer, t.Issuer, "ISSUER")
 					}
 					writer.Write([]byte("\t\n"))
+				case *storage.Revocation:
+					writer.Write([]byte("[REVOCATION]\t\n"))
+					writer.Write([]byte(" id\t" + k + "\n"))
+					writer.Write([]byte(" time\t" + t.Time.Format(time.RFC3339) + "\n"))
+					writer.Write([]byte(" reason\t" + ca.RevocationReasonName(t.Reason) + "\n"))
+					writer.Write([]byte("\t\n"))
+				case *ca.ExpiryStatus:
+					writer.Write([]byte("[EXPIRY]\t\n"))
+					writer.Write([]byte(" id\t" + k + "\n"))
+					writer.Write([]byte(" status\t" + t.Status + "\n"))
+					writer.Write([]byte(" checked\t" + t.Checked.Format(time.RFC3339) + "\n"))
+					writer.Write([]byte("\t\n"))
+				case *storage.StorageKey:
+					writer.Write([]byte("[RENEWAL]\t\n"))
+					writer.Write([]byte(" id\t" + k + "\n"))
+					writer.Write([]byte(" predecessor\t" + t.String() + "\n"))
+					writer.Write([]byte("\t\n"))
 				}
 			}
 		}
 		writer.Flush()
 	case router.ContentTypeJson:
 		data := make(map[string]map[string]interface{}, 0)
-		for k, v := range certs {
+		for _, cert := range certs {
+			k, v := cert.id, cert.items
 			for c, i := len(v), 0; i < c; i++ {
 				item := make(map[string]interface{}, 0)
 				switch t := v[i].(type) {
@@ -72,6 +122,9 @@ func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter,
 					}
 					data[k]["certificate_request"] = item
 				case *x509.Certificate:
+					item["serial"] = t.SerialNumber.Text(16)
+					item["not_before"] = t.NotBefore
+					item["not_after"] = t.NotAfter
 					item["hosts"] = a.mergeHosts(t.DNSNames, t.IPAddresses)
 					item["subject"] = a.nameToMap(t.Subject)
 					if !t.IsCA {
@@ -81,14 +134,41 @@ func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter,
 						data[k] = make(map[string]interface{})
 					}
 					data[k]["certificate"] = item
+				case *storage.Revocation:
+					item["time"] = t.Time
+					item["reason"] = ca.RevocationReasonName(t.Reason)
+					if data[k] == nil {
+						data[k] = make(map[string]interface{})
+					}
+					data[k]["revocation"] = item
+				case *ca.ExpiryStatus:
+					if data[k] == nil {
+						data[k] = make(map[string]interface{})
+					}
+					data[k]["expiry"] = t
+				case *storage.StorageKey:
+					if data[k] == nil {
+						data[k] = make(map[string]interface{})
+					}
+					data[k]["predecessor"] = t.String()
 				}
 			}
 		}
+		var out interface{} = data
+		// the expiring certificates are ordered by expiry so these are returned as list
+		if a.GetPathVar("path", req) == "expiring" {
+			list := make([]map[string]interface{}, 0, len(certs))
+			for _, cert := range certs {
+				data[cert.id]["id"] = cert.id
+				list = append(list, data[cert.id])
+			}
+			out = list
+		}
 		encoder := json.NewEncoder(resp)
 		if _, o := req.URL.Query()["indent"]; o {
 			encoder.SetIndent("", " ")
 		}
-		if err := encoder.Encode(data); err != nil {
+		if err := encoder.Encode(out); err != nil {
 			write_error(resp, err.Error(), http.StatusInternalServerError, logger)
 		}
 	default:
@@ -96,10 +176,21 @@ func (a ApiListController) Handle(req *router.Request, resp http.ResponseWriter,
 	}
 }
 
-func (a ApiListController) getCerts(req *router.Request) (map[string][]interface{}, error) {
+func (a ApiListController) getCerts(req *router.Request, manager *ca.Manager, within time.Duration) ([]*listItem, error) {
 	var path = a.GetPathVar("path", req)
-	var certs = make(map[string][]interface{})
-	err := a.manager.Each(func(r storage.Record) bool {
+	var certs = make([]*listItem, 0)
+	if path == "expiring" {
+		now := time.Now()
+		for _, r := range manager.Expiring(within) {
+			items := []interface{}{r.GetCertificate(), &ca.ExpiryStatus{Status: ca.GetExpiryStatus(r, now, within), NotAfter: r.GetCertificate().NotAfter, Checked: now}}
+			if r.GetPredecessor() != nil {
+				items = append(items, r.GetPredecessor())
+			}
+			certs = append(certs, &listItem{id: r.GetId().String(), items: items})
+		}
+		return certs, nil
+	}
+	err := manager.Each(func(r storage.Record) bool {
 		if path == "ca" && !r.IsCa() {
 			return true
 		}
@@ -117,6 +208,15 @@ func (a ApiListController) getCerts(req *router.Request) (map[string][]interface
 					items = append(items, cert)
 				}
 			}
+			if len(items) > 0 && r.IsRevoked() {
+				items = append(items, r.GetRevocation())
+			}
+			if len(items) > 0 && r.GetPredecessor() != nil {
+				items = append(items, r.GetPredecessor())
+			}
+			if status := manager.GetExpiry(r); len(items) > 0 && status != nil {
+				items = append(items, status)
+			}
 		}
 		if path == "csr" {
 			if cert := r.GetCertificateRequest(); cert != nil {
@@ -124,7 +224,7 @@ func (a ApiListController) getCerts(req *router.Request) (map[string][]interface
 			}
 		}
 		if len(items) > 0 {
-			certs[r.GetId().String()] = items
+			certs = append(certs, &listItem{id: r.GetId().String(), items: items})
 		}
 		return true
 	})
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// ApiSshCaController will serve the public key of the ssh CA in the
+// authorized keys format as used for the TrustedUserCAKeys of sshd.
+type ApiSshCaController struct {
+	ApiCertController
+}
+
+func (a ApiSshCaController) Name() string {
+	return "controller.api.ssh.ca"
+}
+
+func (a ApiSshCaController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "GET"
+}
+
+func NewApiSshCa(registry *ca.Registry) *ApiSshCaController {
+	return &ApiSshCaController{newApiCertController(registry, `^/api/v1/ssh`+patternCa+`/ca$`)}
+}
+
+func (a ApiSshCaController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	key, err := manager.GetSshCaPublicKey()
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "text/plain")
+	resp.Write(ssh.MarshalAuthorizedKey(key))
+}
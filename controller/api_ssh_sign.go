@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// ApiSshSignController will sign a ssh public key into a user or host
+// certificate with the ssh CA, the clients authenticate with basic auth.
+type ApiSshSignController struct {
+	ApiCertController
+	conf *config.SshConfig
+}
+
+func (a ApiSshSignController) Name() string {
+	return "controller.api.ssh.sign"
+}
+
+func (a ApiSshSignController) Match(request *router.Request) bool {
+	return a.Controller.Match(request) && request.Method == "POST"
+}
+
+func NewApiSshSign(registry *ca.Registry, conf *config.Config) *ApiSshSignController {
+	return &ApiSshSignController{
+		ApiCertController: newApiCertController(registry, `^/api/v1/ssh`+patternCa+`/sign$`),
+		conf:              &conf.Ssh,
+	}
+}
+
+func (a ApiSshSignController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := a.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	if !checkBasicAuth(req, a.conf.Username, a.conf.Password) {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="ssh"`)
+		write_error(resp, "authentication required", http.StatusUnauthorized, logger)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.Form.Get("public_key")))
+
+	if err != nil {
+		write_error(resp, "invalid or missing 'public_key', expected a key in the authorized keys format", http.StatusBadRequest, logger)
+		return
+	}
+
+	validity, err := a.getValidity(req.Form)
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	options := ca.SshOptions{
+		Type:            req.Form.Get("type"),
+		KeyId:           req.Form.Get("key_id"),
+		Principals:      req.Form["principal"],
+		Validity:        validity,
+		CriticalOptions: make(map[string]string),
+	}
+
+	if options.Type == "" {
+		options.Type = ca.SSH_CERT_USER
+	}
+
+	for name, option := range map[string]string{"force_command": ca.SSH_OPTION_FORCE_COMMAND, "source_address": ca.SSH_OPTION_SOURCE_ADDRESS} {
+		if value := req.Form.Get(name); value != "" {
+			options.CriticalOptions[option] = value
+		}
+	}
+
+	// an empty extension parameter will result in a certificate without extensions
+	if list, ok := req.Form["extension"]; ok {
+		options.Extensions = make([]string, 0)
+		for _, name := range list {
+			if name != "" {
+				options.Extensions = append(options.Extensions, name)
+			}
+		}
+	}
+
+	record, err := manager.SignSshKey(key, options)
+
+	if err != nil {
+		write_error(resp, err.Error(), error_code(err, http.StatusBadRequest), logger)
+		return
+	}
+
+	switch req.GetAcceptResponseType().MatchFor(router.ContentTypeText | router.ContentTypeJson) {
+	case router.ContentTypeText:
+		resp.Header().Set("Content-Type", "text/plain")
+		if err := record.WriteSshCertificate(resp); err != nil {
+			write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		}
+	case router.ContentTypeJson:
+		cert := record.GetSshCertificate()
+		certType := ca.SSH_CERT_USER
+		if cert.CertType == ssh.HostCert {
+			certType = ca.SSH_CERT_HOST
+		}
+		data := map[string]interface{}{
+			"id":           record.GetId().String(),
+			"type":         certType,
+			"serial":       cert.Serial,
+			"key_id":       cert.KeyId,
+			"principals":   cert.ValidPrincipals,
+			"valid_after":  time.Unix(int64(cert.ValidAfter), 0).UTC(),
+			"valid_before": time.Unix(int64(cert.ValidBefore), 0).UTC(),
+			"certificate":  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		}
+		if err := json.NewEncoder(resp).Encode(data); err != nil {
+			write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		}
+	default:
+		resp.WriteHeader(http.StatusNotAcceptable)
+	}
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"github.com/pbergman/caserver/ca"
 	"github.com/pbergman/logger"
 	"net/http"
 )
@@ -9,3 +10,16 @@ func write_error(w http.ResponseWriter, error string, code int, log logger.Logge
 	log.Error(error)
 	http.Error(w, error, code)
 }
+
+// error_code returns the status code for the error, violations
+// of the issuance policy will result in a forbidden status and
+// invalid requests (like the validity) in a bad request status.
+func error_code(err error, code int) int {
+	switch err.(type) {
+	case *ca.PolicyError:
+		return http.StatusForbidden
+	case *ca.RequestError:
+		return http.StatusBadRequest
+	}
+	return code
+}
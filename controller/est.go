@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
+	"github.com/pbergman/logger"
+)
+
+// EstController serves the (rfc7030) EST enrollment endpoints, the clients
+// authenticate with http basic auth or a (tls) client certificate issued by
+// the CA and the certificates are signed with the configured est profile. A
+// client certificate can only be used to enroll for its own subject and names.
+type EstController struct {
+	ApiCertController
+	conf *config.EstConfig
+}
+
+func (e EstController) Name() string {
+	return "controller.est"
+}
+
+func NewEst(registry *ca.Registry, conf *config.Config) *EstController {
+	return &EstController{
+		ApiCertController: newApiCertController(registry, `^/\.well-known/est`+patternCa+`/(?P<action>cacerts|simpleenroll|simplereenroll)$`),
+		conf:              &conf.Est,
+	}
+}
+
+func (e EstController) Handle(req *router.Request, resp http.ResponseWriter, logger logger.LoggerInterface) {
+	manager := e.getManager(req)
+
+	if manager == nil {
+		write_error(resp, "Unknown certificate authority.", http.StatusNotFound, logger)
+		return
+	}
+
+	action := e.GetPathVar("action", req)
+
+	if action == "cacerts" {
+		if req.Method != "GET" {
+			write_error(resp, "method not allowed", http.StatusMethodNotAllowed, logger)
+			return
+		}
+		if err := e.writeCertificates(resp, e.getCaCertificates(manager)...); err != nil {
+			write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+		}
+		return
+	}
+
+	if req.Method != "POST" {
+		write_error(resp, "method not allowed", http.StatusMethodNotAllowed, logger)
+		return
+	}
+
+	client, basic := e.authenticate(req, manager, logger)
+
+	if client == nil && !basic {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+		write_error(resp, "authentication required", http.StatusUnauthorized, logger)
+		return
+	}
+
+	csr, err := e.readCertificateRequest(req)
+
+	if err != nil {
+		write_error(resp, err.Error(), http.StatusBadRequest, logger)
+		return
+	}
+
+	if csr.Subject.CommonName == "" {
+		write_error(resp, "missing required 'cn' field in csr", http.StatusBadRequest, logger)
+		return
+	}
+
+	record := manager.NewRecord()
+	record.SetCertificateRequest(csr)
+
+	switch {
+	case action == "simplereenroll":
+		// without a client certificate the current certificate is found by the common name
+		if client == nil {
+			client = manager.Search(csr.Subject.CommonName)
+		}
+		if client == nil || !client.HasCertificate() || client.IsCa() || client.IsRevoked() {
+			write_error(resp, "no certificate found to reenroll", http.StatusBadRequest, logger)
+			return
+		}
+		if err := checkReenrollRequest(client.GetCertificate(), csr); err != nil {
+			write_error(resp, err.Error(), http.StatusBadRequest, logger)
+			return
+		}
+		record.SetPredecessor(client.GetId())
+	case !basic:
+		// every certificate issued with the client auth usage could otherwise
+		// be used to enroll for any name, so new names require the basic auth
+		if err := checkReenrollRequest(client.GetCertificate(), csr); err != nil {
+			write_error(resp, "a client certificate can only enroll for its own subject and alternative names, "+err.Error(), http.StatusForbidden, logger)
+			return
+		}
+	}
+
+	if err := manager.SignCertificateRequest(record, e.getIssuer(manager), manager.GetProfile(e.conf.Profile), nil); err != nil {
+		write_error(resp, err.Error(), error_code(err, http.StatusBadRequest), logger)
+		return
+	}
+
+	if err := e.writeCertificates(resp, record.GetCertificate()); err != nil {
+		write_error(resp, err.Error(), http.StatusInternalServerError, logger)
+	}
+}
+
+// authenticate will check the client certificate and basic auth credentials, the
+// returned record is the record of the valid client certificate (or nil) and the
+// bool is true when the client is authenticated with valid basic auth credentials.
+func (e EstController) authenticate(req *router.Request, manager *ca.Manager, logger logger.LoggerInterface) (client storage.Record, basic bool) {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		record, err := manager.VerifyClientCertificate(req.TLS.PeerCertificates[0])
+		if err == nil {
+			client = record
+		} else {
+			logger.Debug("invalid client certificate: " + err.Error())
+		}
+	}
+	basic = checkBasicAuth(req, e.conf.Username, e.conf.Password)
+	return
+}
+
+// checkBasicAuth checks the basic auth credentials of the request, this
+// will always fail when no username is configured.
+func checkBasicAuth(req *router.Request, username, password string) bool {
+	if user, pass, ok := req.BasicAuth(); ok && username != "" {
+		// compare both so the time does not depend on the username matching
+		a := subtle.ConstantTimeCompare([]byte(user), []byte(username))
+		b := subtle.ConstantTimeCompare([]byte(pass), []byte(password))
+		return a&b == 1
+	}
+	return false
+}
+
+// readCertificateRequest will read the base64 encoded (rfc7030 4.2.1)
+// certificate request of the body, a PEM encoded request is also accepted.
+func (e EstController) readCertificateRequest(req *router.Request) (*x509.CertificateRequest, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	if block, _ := pem.Decode(body); block != nil {
+		if block.Type != storage.BLOCK_TYPE_CSR {
+			return nil, errors.New("invalid PEM type")
+		}
+		raw = block.Bytes
+	} else if raw, err = base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(body), nil))); err != nil {
+		return nil, errors.New("expected a base64 encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// getCaCertificates returns the issuer with the intermediates and the (retiring) roots
+func (e EstController) getCaCertificates(manager *ca.Manager) []*x509.Certificate {
+	records := []storage.Record{e.getIssuer(manager)}
+	records = append(records, manager.GetChain(records[0])...)
+	records = append(records, manager.GetRoots()...)
+	certs := make([]*x509.Certificate, 0, len(records))
+	seen := make(map[string]bool)
+	for _, record := range records {
+		if record == nil || !record.HasCertificate() || seen[string(record.GetCertificate().Raw)] {
+			continue
+		}
+		seen[string(record.GetCertificate().Raw)] = true
+		certs = append(certs, record.GetCertificate())
+	}
+	return certs
+}
+
+// writeCertificates will write the certificates as base64 encoded
+// certs-only PKCS #7 as described in rfc7030 4.1.3 and 4.2.3.
+func (e EstController) writeCertificates(resp http.ResponseWriter, certs ...*x509.Certificate) error {
+	raw, err := util.MarshalPKCS7(certs...)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	resp.Header().Set("Content-Transfer-Encoding", "base64")
+	resp.WriteHeader(http.StatusOK)
+	_, err = resp.Write([]byte(base64.StdEncoding.EncodeToString(raw)))
+	return err
+}
+
+// checkReenrollRequest checks that the subject and alternative names of the request
+// are the same as the current certificate, which is required by rfc7030 4.2.2.
+func checkReenrollRequest(cert *x509.Certificate, csr *x509.CertificateRequest) error {
+	subject := csr.Subject
+	// the serial number is added to the subject when issued (see factory.checkSubject)
+	if subject.SerialNumber == "" {
+		subject.SerialNumber = cert.Subject.SerialNumber
+	}
+	if cert.Subject.String() != subject.String() {
+		return errors.New("the subject of the request does not match the current certificate")
+	}
+	current := sanList(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs)
+	requested := sanList(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+	if strings.Join(current, ",") != strings.Join(requested, ",") {
+		return errors.New("the alternative names of the request do not match the current certificate")
+	}
+	return nil
+}
+
+// sanList returns the sorted alternative names prefixed with their type
+func sanList(dns []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	list := make([]string, 0)
+	for _, name := range dns {
+		list = append(list, "dns:"+name)
+	}
+	for _, ip := range ips {
+		list = append(list, "ip:"+ip.String())
+	}
+	for _, email := range emails {
+		list = append(list, "email:"+email)
+	}
+	for _, uri := range uris {
+		list = append(list, "uri:"+uri.String())
+	}
+	sort.Strings(list)
+	return list
+}
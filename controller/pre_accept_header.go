@@ -27,7 +27,21 @@ func (p PreAcceptHeader) Handle(request *router.Request, header http.Header, log
 	case "tar.gz":
 		p.prefixAcceptHeader(request.Header, "application/tar+gzip")
 	case "pem":
+		p.prefixAcceptHeader(request.Header, "application/x-pem-file")
+	case "der", "cer":
 		p.prefixAcceptHeader(request.Header, "application/pkix-cert")
+	case "p8":
+		p.prefixAcceptHeader(request.Header, "application/pkcs8")
+	case "p7b":
+		p.prefixAcceptHeader(request.Header, "application/x-pkcs7-certificates")
+	case "crt":
+		p.prefixAcceptHeader(request.Header, "application/x-x509-ca-cert")
+	case "crl":
+		p.prefixAcceptHeader(request.Header, "application/pkix-crl")
+	case "p12":
+		p.prefixAcceptHeader(request.Header, "application/x-pkcs12")
+	case "jks":
+		p.prefixAcceptHeader(request.Header, "application/x-java-keystore")
 	case "text", "txt":
 		p.prefixAcceptHeader(request.Header, "text/plain")
 	}
@@ -43,6 +57,6 @@ func (p PreAcceptHeader) prefixAcceptHeader(header http.Header, value string) {
 
 func NewPreAcceptHeaderHook() router.PreControllerInterface {
 	return &PreAcceptHeader{
-		Controller: newController(`^/api/v1/.+(?:\.(?P<ext>json|tar(?:\.gz)?|pem|t(?:e)?xt))$`),
+		Controller: newController(`^/api/v1/.+(?:\.(?P<ext>json|tar(?:\.gz)?|pem|der|cer|crl|crt|p12|p8|p7b|jks|t(?:e)?xt))$`),
 	}
 }
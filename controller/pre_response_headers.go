@@ -34,6 +34,27 @@ func (p PreResponseHeaders) Handle(request *router.Request, header http.Header,
 	case router.ContentTypePkixCert:
 		header.Set("Content-Type", "application/pkix-cert")
 		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypePkixCrl:
+		header.Set("Content-Type", "application/pkix-crl")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypePkcs12:
+		header.Set("Content-Type", "application/x-pkcs12")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypeJks:
+		header.Set("Content-Type", "application/x-java-keystore")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypePem:
+		header.Set("Content-Type", "application/x-pem-file")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypePkcs8:
+		header.Set("Content-Type", "application/pkcs8")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypePkcs7:
+		header.Set("Content-Type", "application/x-pkcs7-certificates")
+		header.Set("X-Content-Type-Options", "nosniff")
+	case router.ContentTypeX509CaCert:
+		header.Set("Content-Type", "application/x-x509-ca-cert")
+		header.Set("X-Content-Type-Options", "nosniff")
 	case router.ContentTypeText:
 		header.Set("Content-Type", "text/plain; charset=utf-8")
 		header.Set("X-Content-Type-Options", "nosniff")
@@ -4,9 +4,12 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -14,8 +17,33 @@ import (
 	"github.com/pbergman/caserver/router"
 	"github.com/pbergman/caserver/storage"
 	"github.com/pbergman/caserver/util"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
+// statusResponseWriter will write the status when the response is written
+// without an explicit status, so errors can still set their own status.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func newStatusResponseWriter(writer http.ResponseWriter, status int) *statusResponseWriter {
+	return &statusResponseWriter{ResponseWriter: writer, status: status}
+}
+
+func (s *statusResponseWriter) WriteHeader(code int) {
+	s.wroteHeader = true
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusResponseWriter) Write(buf []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(s.status)
+	}
+	return s.ResponseWriter.Write(buf)
+}
+
 func tarFileHeader(name string, size int64) *tar.Header {
 	return &tar.Header{
 		Name:    name,
@@ -26,7 +54,7 @@ func tarFileHeader(name string, size int64) *tar.Header {
 }
 
 // writeTar writes a tar file of available fields in record
-func writeTarResponse(writer io.Writer, ca, cer storage.Record) error {
+func writeTarResponse(writer io.Writer, chain []storage.Record, cer storage.Record) error {
 	if !cer.HasCertificate() {
 		return errors.New("can not write a tar file without a certificate")
 	}
@@ -52,11 +80,9 @@ func writeTarResponse(writer io.Writer, ca, cer storage.Record) error {
 		}
 	}
 	if cer.HasCertificate() {
-		var size int64
-		if ca != nil {
-			size = cer.BlockPemLen() + ca.BlockPemLen()
-		} else {
-			size = cer.BlockPemLen()
+		var size = cer.BlockPemLen()
+		for _, ca := range chain {
+			size += ca.BlockPemLen()
 		}
 		// add certificate
 		if err := tarWriter.WriteHeader(tarFileHeader(name+".pem", size)); err != nil {
@@ -65,8 +91,8 @@ func writeTarResponse(writer io.Writer, ca, cer storage.Record) error {
 		if err := cer.WriteCertificate(tarWriter); err != nil {
 			return err
 		}
-		// add chained ca certificate when available
-		if ca != nil {
+		// add chained ca certificates when available
+		for _, ca := range chain {
 			if err := ca.WriteCertificate(tarWriter); err != nil {
 				return err
 			}
@@ -76,14 +102,117 @@ func writeTarResponse(writer io.Writer, ca, cer storage.Record) error {
 }
 
 // writeTarGzResponse will add gzip compression to the tar writer
-func writeTarGzResponse(writer io.Writer, ca, record storage.Record) error {
+func writeTarGzResponse(writer io.Writer, chain []storage.Record, record storage.Record) error {
 	gzipWriter := gzip.NewWriter(writer)
 	defer gzipWriter.Close()
-	return writeTarResponse(gzipWriter, ca, record)
+	return writeTarResponse(gzipWriter, chain, record)
+}
+
+// writePkcs12Response will write the key, certificate and chain as a PKCS #12
+// file encrypted with the given password (AES-256 and PBKDF2).
+func writePkcs12Response(writer io.Writer, chain []storage.Record, cer storage.Record, password string) error {
+	if !cer.HasCertificate() || !cer.HasPrivateKey() {
+		return errors.New("can not write a pkcs12 file without a certificate and private key")
+	}
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for _, ca := range chain {
+		certs = append(certs, ca.GetCertificate())
+	}
+	raw, err := pkcs12.Modern.Encode(cer.GetPrivateKey(), cer.GetCertificate(), certs, password)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(raw)
+	return err
+}
+
+// writeJksResponse will write a java keystore with the key and chain as private key
+// entry, without a private key (like the CA) a truststore is written that holds the
+// certificate and chain as trusted certificate entries.
+func writeJksResponse(writer io.Writer, chain []storage.Record, cer storage.Record, alias, password string) error {
+	if !cer.HasCertificate() {
+		return errors.New("can not write a keystore without a certificate")
+	}
+	certs := []*x509.Certificate{cer.GetCertificate()}
+	for _, ca := range chain {
+		certs = append(certs, ca.GetCertificate())
+	}
+	var entries []util.JksEntry
+	if cer.HasPrivateKey() {
+		entries = append(entries, util.JksEntry{Alias: alias, Key: cer.GetPrivateKey(), Certs: certs})
+	} else {
+		seen := make(map[string]int)
+		for i, cert := range certs {
+			name := util.Slug(cert.Subject.CommonName)
+			if i == 0 {
+				name = alias
+			}
+			// suffix the alias when certificates have the same common name
+			if seen[name]++; seen[name] > 1 {
+				name = fmt.Sprintf("%s-%d", name, seen[name])
+			}
+			entries = append(entries, util.JksEntry{Alias: name, Certs: []*x509.Certificate{cert}})
+		}
+	}
+	raw, err := util.MarshalJKS(password, entries...)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(raw)
+	return err
+}
+
+// writeDerResponse will write the DER encoded certificate
+func writeDerResponse(writer io.Writer, cer storage.Record) error {
+	if !cer.HasCertificate() {
+		return errors.New("can not write a DER file without a certificate")
+	}
+	_, err := writer.Write(cer.GetCertificate().Raw)
+	return err
+}
+
+// writePkcs8Response will write the DER encoded PKCS #8 private key
+func writePkcs8Response(writer io.Writer, cer storage.Record) error {
+	if !cer.HasPrivateKey() {
+		return errors.New("can not write a pkcs8 file without a private key")
+	}
+	raw, err := x509.MarshalPKCS8PrivateKey(cer.GetPrivateKey())
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(raw)
+	return err
+}
+
+// writePkcs7Response will write the certificate and chain as a DER
+// encoded certs-only PKCS #7 file (the windows .p7b format).
+func writePkcs7Response(writer io.Writer, chain []storage.Record, cer storage.Record) error {
+	if !cer.HasCertificate() {
+		return errors.New("can not write a pkcs7 file without a certificate")
+	}
+	certs := []*x509.Certificate{cer.GetCertificate()}
+	for _, ca := range chain {
+		certs = append(certs, ca.GetCertificate())
+	}
+	raw, err := util.MarshalPKCS7(certs...)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(raw)
+	return err
+}
+
+// storePassword returns the password for a PKCS #12 or keystore response
+// from the password parameter or the given header.
+func storePassword(req *router.Request, header string) string {
+	if password := req.Header.Get(header); password != "" {
+		return password
+	}
+	return req.FormValue("password")
 }
 
 // writeTextResponse will key, pem and csr (if available) to writer
-func writeTextResponse(writer io.Writer, ca, record storage.Record) error {
+func writeTextResponse(writer io.Writer, chain []storage.Record, record storage.Record) error {
 	if record.HasPrivateKey() {
 		if err := record.WritePrivateKey(writer); err != nil {
 			return nil
@@ -98,7 +227,7 @@ func writeTextResponse(writer io.Writer, ca, record storage.Record) error {
 		if err := record.WriteCertificate(writer); err != nil {
 			return nil
 		}
-		if ca != nil {
+		for _, ca := range chain {
 			if err := ca.WriteCertificate(writer); err != nil {
 				return nil
 			}
@@ -108,7 +237,7 @@ func writeTextResponse(writer io.Writer, ca, record storage.Record) error {
 }
 
 // writeJsonResponse will write a json response
-func writeJsonResponse(writer io.Writer, indent bool, ca, record storage.Record) error {
+func writeJsonResponse(writer io.Writer, indent bool, chain []storage.Record, record storage.Record) error {
 	data := make(map[string]interface{}, 0)
 	buf := new(bytes.Buffer)
 	if record.HasPrivateKey() {
@@ -129,7 +258,7 @@ func writeJsonResponse(writer io.Writer, indent bool, ca, record storage.Record)
 		if err := record.WriteCertificate(buf); err != nil {
 			return err
 		}
-		if ca != nil {
+		for _, ca := range chain {
 			if err := ca.WriteCertificate(buf); err != nil {
 				return err
 			}
@@ -144,6 +273,30 @@ func writeJsonResponse(writer io.Writer, indent bool, ca, record storage.Record)
 	return enc.Encode(data)
 }
 
+// WriteCrlResponse will write the DER encoded CRL as DER, PEM or json
+func WriteCrlResponse(req *router.Request, resp http.ResponseWriter, crl []byte, name string) error {
+	block := &pem.Block{Type: storage.BLOCK_TYPE_CRL, Bytes: crl}
+	switch req.GetAcceptResponseType().MatchFor(router.ContentTypeText | router.ContentTypeJson | router.ContentTypePkixCert | router.ContentTypePkixCrl | router.ContentTypePem) {
+	case router.ContentTypePkixCrl:
+		resp.Header().Set("Content-Type", "application/pkix-crl")
+		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".crl\"")
+		_, err := resp.Write(crl)
+		return err
+	case router.ContentTypeJson:
+		enc := json.NewEncoder(resp)
+		if _, o := req.URL.Query()["indent"]; o {
+			enc.SetIndent("", " ")
+		}
+		return enc.Encode(map[string]string{"crl": string(pem.EncodeToMemory(block))})
+	case router.ContentTypeText, router.ContentTypePkixCert, router.ContentTypePem:
+		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".crl.pem\"")
+		return pem.Encode(resp, block)
+	default:
+		resp.WriteHeader(http.StatusNotAcceptable)
+	}
+	return nil
+}
+
 func nameFromRecord(record storage.Record) string {
 	if record.GetId() != nil {
 		return record.GetId().String()
@@ -162,7 +315,9 @@ func nameFromRecord(record storage.Record) string {
 	return hex.EncodeToString(buf)
 }
 
-func WriteResponse(req *router.Request, resp http.ResponseWriter, caRecord, cerRecord storage.Record) error {
+// WriteResponse will write the record in the negotiated content type, the chain
+// holds the CA certificates that will be appended to the certificate.
+func WriteResponse(req *router.Request, resp http.ResponseWriter, chain []storage.Record, cerRecord storage.Record) error {
 	name := nameFromRecord(cerRecord)
 	switch req.GetAcceptResponseType().MatchFor(router.ContentTypeAll) {
 	case router.ContentTypeJson:
@@ -170,19 +325,74 @@ func WriteResponse(req *router.Request, resp http.ResponseWriter, caRecord, cerR
 		if _, o := req.URL.Query()["indent"]; o {
 			indent = true
 		}
-		return writeJsonResponse(resp, indent, caRecord, cerRecord)
+		return writeJsonResponse(resp, indent, chain, cerRecord)
 	case router.ContentTypeText:
 		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".txt\"")
-		return writeTextResponse(resp, caRecord, cerRecord)
+		return writeTextResponse(resp, chain, cerRecord)
 	case router.ContentTypeTar:
 		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".tar\"")
-		return writeTarResponse(resp, caRecord, cerRecord)
+		return writeTarResponse(resp, chain, cerRecord)
 	case router.ContentTypeTarGzip:
 		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".tar.gz\"")
-		return writeTarGzResponse(resp, caRecord, cerRecord)
-	case router.ContentTypePkixCert:
+		return writeTarGzResponse(resp, chain, cerRecord)
+	case router.ContentTypePem:
 		resp.Header().Set("Content-Disposition", "inline; filename=\""+name+".pem\"")
-		return writeTextResponse(resp, caRecord, cerRecord)
+		return writeTextResponse(resp, chain, cerRecord)
+	case router.ContentTypePkixCert:
+		if !cerRecord.HasCertificate() {
+			http.Error(resp, "a DER file can only be created for a certificate", http.StatusNotAcceptable)
+			return nil
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".cer\"")
+		return writeDerResponse(resp, cerRecord)
+	case router.ContentTypeX509CaCert:
+		if !cerRecord.HasCertificate() || !cerRecord.GetCertificate().IsCA {
+			http.Error(resp, "a x509 ca file can only be created for a CA certificate", http.StatusNotAcceptable)
+			return nil
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".crt\"")
+		return writeDerResponse(resp, cerRecord)
+	case router.ContentTypePkcs8:
+		if !cerRecord.HasPrivateKey() {
+			http.Error(resp, "a pkcs8 file can only be created for a certificate with a private key", http.StatusNotAcceptable)
+			return nil
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".p8\"")
+		return writePkcs8Response(resp, cerRecord)
+	case router.ContentTypePkcs7:
+		if !cerRecord.HasCertificate() {
+			http.Error(resp, "a pkcs7 file can only be created for a certificate", http.StatusNotAcceptable)
+			return nil
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".p7b\"")
+		return writePkcs7Response(resp, chain, cerRecord)
+	case router.ContentTypePkcs12:
+		password := storePassword(req, "X-Pkcs12-Password")
+		if password == "" {
+			http.Error(resp, "a pkcs12 file requires the 'password' parameter or X-Pkcs12-Password header", http.StatusBadRequest)
+			return nil
+		}
+		if !cerRecord.HasCertificate() || !cerRecord.HasPrivateKey() {
+			http.Error(resp, "a pkcs12 file can only be created for a certificate with a private key", http.StatusNotAcceptable)
+			return nil
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".p12\"")
+		return writePkcs12Response(resp, chain, cerRecord, password)
+	case router.ContentTypeJks:
+		password := storePassword(req, "X-Jks-Password")
+		if password == "" {
+			http.Error(resp, "a keystore requires the 'password' parameter or X-Jks-Password header", http.StatusBadRequest)
+			return nil
+		}
+		alias := req.FormValue("alias")
+		if alias == "" && cerRecord.HasCertificate() {
+			alias = util.Slug(cerRecord.GetCertificate().Subject.CommonName)
+		}
+		if alias == "" {
+			alias = name
+		}
+		resp.Header().Set("Content-Disposition", "attachment; filename=\""+name+".jks\"")
+		return writeJksResponse(resp, chain, cerRecord, alias, password)
 	default:
 		resp.WriteHeader(http.StatusNotAcceptable)
 	}
@@ -0,0 +1,146 @@
+package controller_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pbergman/caserver/ca"
+	"github.com/pbergman/caserver/config"
+	"github.com/pbergman/caserver/controller"
+	"github.com/pbergman/caserver/router"
+	"github.com/pbergman/caserver/storage"
+	"github.com/pbergman/caserver/util"
+	"github.com/pbergman/logger"
+	"github.com/pbergman/logger/handlers"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newTestServer starts the certificate endpoints for a CA with an intermediate
+// and returns the server and manager of the CA, the update callback can be
+// used to change the config before the CA is created.
+func newTestServer(t *testing.T, update func(*config.Config)) (*httptest.Server, *ca.Manager) {
+	conf := new(config.Config)
+	util.SetDefaults(conf)
+	conf.Path = t.TempDir()
+	conf.CaNotAfter = [3]int{1, 0, 0}
+	conf.PemNotAfter = [3]int{0, 3, 0}
+	authority := &config.CaConfig{Name: config.DEFAULT_CA, Subject: &pkix.Name{CommonName: "example CA"}, Intermediates: 1}
+	authority.Key.Type = ca.KEY_TYPE_ECDSA
+	conf.Authorities = []*config.CaConfig{authority}
+	if update != nil {
+		update(conf)
+	}
+	registry, err := ca.NewRegistry(conf, func(name string) storage.Storage {
+		return storage.NewDiskStorage(t.TempDir(), &conf.Key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.NewLogger("test", handlers.NewWriterHandler(ioutil.Discard, logger.ERROR))
+	handler := router.NewRouter(
+		log,
+		controller.NewApiCertSign(registry),
+		controller.NewApiCertCreate(registry),
+		controller.NewApiCertGet(registry),
+		controller.NewApiCertRevoke(registry),
+		controller.NewApiCertRenew(registry),
+		controller.NewApiList(registry, conf),
+	)
+	handler.AddPreHook(controller.NewPreAcceptHeaderHook())
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, registry.Get("")
+}
+
+// createCertificate will create a new certificate and returns the location of the record
+func createCertificate(t *testing.T, server *httptest.Server, values url.Values) string {
+	resp, err := http.PostForm(server.URL+"/api/v1/cert", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expected status 201 got %d: %s", resp.StatusCode, body)
+	}
+	return resp.Header.Get("Location")
+}
+
+func TestWriteResponse_Pkcs12(t *testing.T) {
+	server, manager := newTestServer(t, nil)
+	location := createCertificate(t, server, url.Values{"cn": {"example.com"}, "key_type": {"ecdsa"}})
+
+	resp, err := http.Get(server.URL + location + ".p12?password=secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	raw, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d: %s", resp.StatusCode, raw)
+	}
+
+	key, leaf, chain, err := pkcs12.DecodeChain(raw, "secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := manager.Lookup(location[len("/api/v1/cert/"):])
+
+	if !bytes.Equal(leaf.Raw, record.GetCertificate().Raw) {
+		t.Fatal("expected the certificate of the record")
+	}
+
+	if signer, ok := key.(crypto.Signer); !ok || !record.GetPrivateKey().Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+		t.Fatal("expected the private key of the record")
+	}
+
+	expected := manager.GetChain(record)
+
+	if len(chain) != len(expected) {
+		t.Fatalf("expected a chain of %d certificates got %d", len(expected), len(chain))
+	}
+
+	for i, cert := range chain {
+		if !bytes.Equal(cert.Raw, expected[i].GetCertificate().Raw) {
+			t.Fatalf("expected certificate %d of the chain to be %s", i, expected[i].GetCertificate().Subject)
+		}
+	}
+
+	if _, _, _, err := pkcs12.DecodeChain(raw, "other"); err == nil {
+		t.Fatal("expected an error for the wrong password")
+	}
+}
+
+func TestWriteResponse_Pkcs12WithoutCertificate(t *testing.T) {
+	_, manager := newTestServer(t, nil)
+	key, err := ca.KeyOptions{Type: ca.KEY_TYPE_ECDSA}.Generate()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := manager.NewRecord()
+	record.SetPrivateKey(key)
+	req := &router.Request{Request: httptest.NewRequest("GET", "/api/v1/cert/example?password=secret", nil)}
+	req.Header.Set("accept", "application/x-pkcs12")
+	resp := httptest.NewRecorder()
+
+	if err := controller.WriteResponse(req, resp, nil, record); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected status 406 got %d", resp.Code)
+	}
+}
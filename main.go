@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pbergman/caserver/ca"
 	"github.com/pbergman/caserver/config"
@@ -22,6 +24,8 @@ func main() {
 	var debug bool
 	pflag.StringVarP(&file, "config", "c", "/etc/caserver.cnf", "The application config file.")
 	pflag.BoolVarP(&debug, "debug", "d", false, "This will print debug levels and add debug routing (see: 'net/http/pprof').")
+	// stop at the first argument so commands can have their own flags
+	pflag.CommandLine.SetInterspersed(false)
 	pflag.Parse()
 	conf, err := getConfig(file)
 	log := getLogger(debug)
@@ -29,39 +33,139 @@ func main() {
 		log.Error(err)
 		return
 	}
-	manager, err := ca.NewManager(conf, storage.NewDiskStorage(filepath.Join(conf.Path, "storage"), &conf.Key))
+	if pflag.NArg() >= 2 && pflag.Arg(0) == "ca" && pflag.Arg(1) == "import" {
+		if err := importCa(conf, pflag.Args()[2:]); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+	registry, err := ca.NewRegistry(conf, func(name string) storage.Storage {
+		return storage.NewDiskStorage(getStoragePath(conf, name), &conf.Key)
+	})
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	go scheduleCrlUpdates(log, registry, conf.CrlInterval)
+	go scheduleExpiryScans(log, registry, conf.ExpiryInterval, conf.ExpiryWarning)
 	log.Debug(fmt.Sprintf("Starting server '%s'", conf.Address))
-	if err := http.ListenAndServe(conf.Address, getRouter(log, manager, debug)); err != nil {
+	if err := listenAndServe(conf, getRouter(log, registry, conf, debug)); err != nil {
 		log.Error(err)
 	}
 }
 
-func getRouter(log *logger.Logger, manager *ca.Manager, debug bool) http.Handler {
-	handler := router.NewRouter(log, getControllers(manager, debug)...)
+// listenAndServe will serve https when a certificate is configured, client
+// certificates are requested (but not required) so they can be used for
+// authentication by the controllers.
+func listenAndServe(conf *config.Config, handler http.Handler) error {
+	if conf.TlsCert == "" {
+		return http.ListenAndServe(conf.Address, handler)
+	}
+	server := &http.Server{
+		Addr:      conf.Address,
+		Handler:   handler,
+		TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert},
+	}
+	return server.ListenAndServeTLS(conf.TlsCert, conf.TlsKey)
+}
+
+// importCa will handle the `ca import` command that bootstraps a CA with
+// an existing root certificate and key instead of generating a new root.
+func importCa(conf *config.Config, args []string) error {
+	var name string
+	var files config.ImportConfig
+	flags := pflag.NewFlagSet("ca import", pflag.ContinueOnError)
+	flags.StringVarP(&name, "name", "n", config.DEFAULT_CA, "The name of the CA to import the root for.")
+	flags.StringVar(&files.Cert, "cert", "", "The pem encoded CA certificate.")
+	flags.StringVar(&files.Key, "key", "", "The pem encoded (optionally encrypted) private key.")
+	flags.StringVar(&files.Password, "password", "", "The password of an encrypted private key.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	authority := conf.GetAuthority(name)
+	if authority == nil {
+		return fmt.Errorf("no CA configured with the name '%s'", name)
+	}
+	authority.Import = files
+	manager, err := ca.ImportCertificateAuthority(conf, authority, storage.NewDiskStorage(getStoragePath(conf, authority.Name), &conf.Key))
+	if err != nil {
+		return err
+	}
+	root := manager.Get(manager.GetCa())
+	fmt.Printf("Imported root '%s' (%s) for the CA '%s'\n", root.GetCertificate().Subject.CommonName, root.GetId(), authority.Name)
+	return nil
+}
+
+func getRouter(log *logger.Logger, registry *ca.Registry, conf *config.Config, debug bool) http.Handler {
+	handler := router.NewRouter(log, getControllers(registry, conf, debug)...)
 	handler.AddPreHook(controller.NewPreAcceptHeaderHook())
 	handler.AddPreHook(&controller.PreResponseHeaders{})
 	return handler
 }
 
-func getControllers(manager *ca.Manager, debug bool) []router.ControllerInterface {
+func getControllers(registry *ca.Registry, conf *config.Config, debug bool) []router.ControllerInterface {
 	controllers := []router.ControllerInterface{
-		controller.NewApiCa(manager),
-		controller.NewApiCertSign(manager),
-		controller.NewApiCertCreate(manager),
-		controller.NewApiCertDelete(manager),
-		controller.NewApiCertGet(manager),
-		controller.NewApiList(manager),
+		controller.NewApiCaCrl(registry),
+		controller.NewApiCaOcsp(registry),
+		controller.NewApiCaIssuer(registry),
+		controller.NewApiCaBundle(registry),
+		controller.NewApiCaRollover(registry),
+		controller.NewApiCa(registry),
+		controller.NewApiCertSign(registry),
+		controller.NewApiCertCreate(registry),
+		controller.NewApiCertDelete(registry),
+		controller.NewApiCertGet(registry),
+		controller.NewApiCertRevoke(registry),
+		controller.NewApiCertRenew(registry),
+		controller.NewApiList(registry, conf),
 		controller.CorsController{},
-		controller.NewDebug(),
+	}
+	if conf.Acme.Enabled {
+		controllers = append(controllers, controller.NewAcme(registry, conf))
+	}
+	if conf.Ssh.Enabled {
+		controllers = append(controllers, controller.NewApiSshCa(registry), controller.NewApiSshSign(registry, conf))
+	}
+	if conf.Est.Enabled {
+		controllers = append(controllers, controller.NewEst(registry, conf))
 	}
 	if debug {
-		return controllers
-	} else {
-		return controllers[:len(controllers)-1]
+		controllers = append(controllers, controller.NewDebug())
+	}
+	return controllers
+}
+
+// scheduleCrlUpdates will regenerate the CRL`s of all CA`s every interval
+func scheduleCrlUpdates(log *logger.Logger, registry *ca.Registry, interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, name := range registry.Names() {
+			if err := registry.Get(name).UpdateCRLs(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// scheduleExpiryScans will check the expiry of the certificates of all CA`s at the
+// start and every interval and log a warning for every expiring certificate.
+func scheduleExpiryScans(log *logger.Logger, registry *ca.Registry, interval, within time.Duration) {
+	for {
+		for _, name := range registry.Names() {
+			for _, record := range registry.Get(name).ScanExpiry(within) {
+				cert := record.GetCertificate()
+				kind := "certificate"
+				if record.IsCa() {
+					kind = "CA certificate"
+				}
+				status := "expires"
+				if time.Now().After(cert.NotAfter) {
+					status = "expired"
+				}
+				log.Warning(fmt.Sprintf("the %s '%s' (%s) of CA '%s' %s at %s", kind, cert.Subject.CommonName, record.GetId(), name, status, cert.NotAfter.Format(time.RFC3339)))
+			}
+		}
+		time.Sleep(interval)
 	}
 }
 
@@ -77,6 +181,15 @@ func getLogger(debug bool) *logger.Logger {
 	return logger.NewLogger("main", handler)
 }
 
+// getStoragePath returns the storage directory for the named CA, the
+// default CA will use the storage root so existing setups keep working.
+func getStoragePath(conf *config.Config, name string) string {
+	if name == config.DEFAULT_CA {
+		return filepath.Join(conf.Path, "storage")
+	}
+	return filepath.Join(conf.Path, "storage", name)
+}
+
 func getConfig(file string) (*config.Config, error) {
 	cnf := new(config.Config)
 	util.SetDefaults(cnf)
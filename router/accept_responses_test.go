@@ -11,7 +11,7 @@ func TestAcceptResponses_MatchFor(t *testing.T) {
 		t.Fatalf("expected %s got %s", ContentTypeJson, act)
 	}
 
-	if act := accept.MatchFor(ContentTypeAll ^ (ContentTypeJson | ContentTypeTar | ContentTypeTarGzip | ContentTypePkixCert)); act != ContentTypeText {
+	if act := accept.MatchFor(ContentTypeAll ^ (ContentTypeJson | ContentTypeTar | ContentTypeTarGzip | ContentTypePkixCert | ContentTypePkixCrl | ContentTypePkcs12 | ContentTypeJks | ContentTypePem | ContentTypePkcs8 | ContentTypePkcs7 | ContentTypeX509CaCert)); act != ContentTypeText {
 		t.Fatalf("expected %s got %s", ContentTypeText, act)
 	}
 
@@ -67,3 +67,38 @@ func TestNewAcceptResponses(t *testing.T) {
 		t.Fatalf("expected */* got %s", s)
 	}
 }
+
+func TestAcceptResponses_MatchFor_crl(t *testing.T) {
+	accept := NewAcceptResponses("application/pkix-crl;q=9.0, */*")
+
+	if act := accept.MatchFor(ContentTypeAll); act != ContentTypePkixCrl {
+		t.Fatalf("expected %s got %s", ContentTypePkixCrl, act)
+	}
+}
+
+func TestAcceptResponses_MatchFor_pkcs12(t *testing.T) {
+	accept := NewAcceptResponses("application/x-pkcs12;q=9.0, */*")
+
+	if act := accept.MatchFor(ContentTypeAll); act != ContentTypePkcs12 {
+		t.Fatalf("expected %s got %s", ContentTypePkcs12, act)
+	}
+}
+
+func TestAcceptResponses_MatchFor_jks(t *testing.T) {
+	accept := NewAcceptResponses("application/x-java-keystore;q=9.0, */*")
+
+	if act := accept.MatchFor(ContentTypeAll); act != ContentTypeJks {
+		t.Fatalf("expected %s got %s", ContentTypeJks, act)
+	}
+}
+
+func TestContentTypeFromString(t *testing.T) {
+	for _, ct := range []ContentType{ContentTypePem, ContentTypePkcs8, ContentTypePkcs7, ContentTypeX509CaCert} {
+		if act := ContentTypeFromString(ct.String()); act != ct {
+			t.Fatalf("expected %s got %s", ct, act)
+		}
+		if act := NewAcceptResponses(ct.String() + ";q=9.0, */*").MatchFor(ContentTypeAll); act != ct {
+			t.Fatalf("expected %s got %s", ct, act)
+		}
+	}
+}
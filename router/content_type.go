@@ -8,8 +8,16 @@ const (
 	ContentTypeTar
 	ContentTypeTarGzip
 	ContentTypePkixCert
+	ContentTypePkixCrl
+	ContentTypePkcs12
+	ContentTypeJks
+	ContentTypePem
+	ContentTypePkcs8
+	ContentTypePkcs7
+	ContentTypeX509CaCert
 
-	ContentTypeAll ContentType = ContentTypeText | ContentTypeJson | ContentTypeTar | ContentTypeTarGzip | ContentTypePkixCert
+	ContentTypeAll ContentType = ContentTypeText | ContentTypeJson | ContentTypeTar | ContentTypeTarGzip | ContentTypePkixCert | ContentTypePkixCrl | ContentTypePkcs12 | ContentTypeJks |
+		ContentTypePem | ContentTypePkcs8 | ContentTypePkcs7 | ContentTypeX509CaCert
 )
 
 func ContentTypeFromString(types ...string) ContentType {
@@ -26,6 +34,20 @@ func ContentTypeFromString(types ...string) ContentType {
 			ct |= ContentTypeTarGzip
 		case "application/pkix-cert":
 			ct |= ContentTypePkixCert
+		case "application/pkix-crl":
+			ct |= ContentTypePkixCrl
+		case "application/x-pkcs12":
+			ct |= ContentTypePkcs12
+		case "application/x-java-keystore":
+			ct |= ContentTypeJks
+		case "application/x-pem-file":
+			ct |= ContentTypePem
+		case "application/pkcs8":
+			ct |= ContentTypePkcs8
+		case "application/x-pkcs7-certificates":
+			ct |= ContentTypePkcs7
+		case "application/x-x509-ca-cert":
+			ct |= ContentTypeX509CaCert
 		}
 	}
 	return ct
@@ -46,6 +68,20 @@ func (c ContentType) String() string {
 				buf += ", application/tar+gzip"
 			case ContentTypePkixCert:
 				buf += ", application/pkix-cert"
+			case ContentTypePkixCrl:
+				buf += ", application/pkix-crl"
+			case ContentTypePkcs12:
+				buf += ", application/x-pkcs12"
+			case ContentTypeJks:
+				buf += ", application/x-java-keystore"
+			case ContentTypePem:
+				buf += ", application/x-pem-file"
+			case ContentTypePkcs8:
+				buf += ", application/pkcs8"
+			case ContentTypePkcs7:
+				buf += ", application/x-pkcs7-certificates"
+			case ContentTypeX509CaCert:
+				buf += ", application/x-x509-ca-cert"
 			}
 		}
 	}
@@ -1,26 +1,60 @@
 package storage
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"io"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
+// Revocation holds the time and reason (see rfc5280 5.3.1)
+// of a revoked certificate.
+type Revocation struct {
+	Time   time.Time `json:"time"`
+	Reason int       `json:"reason"`
+}
+
 type Record interface {
 	GetId() *StorageKey
 	IsCa() bool
+	// a retiring CA is replaced by a new CA but will
+	// be trusted until it expires (see rollover)
+	IsRetiring() bool
+	SetRetiring(bool)
+	// revocation of the certificate, will be nil when not revoked
+	GetRevocation() *Revocation
+	SetRevocation(*Revocation)
+	IsRevoked() bool
+	// the record this record is a renewal of, will be
+	// nil when the record is not created by a renewal
+	GetPredecessor() *StorageKey
+	SetPredecessor(*StorageKey)
+	// the name of the profile the certificate is issued
+	// with, empty when issued with the default profile
+	GetProfile() string
+	SetProfile(string)
+	// the key of the ssh certificate authority is saved
+	// as a record with only the private key
+	IsSshCa() bool
+	SetSshCa(bool)
 	// getter
-	GetPrivateKey() *rsa.PrivateKey
+	GetPrivateKey() crypto.Signer
 	GetCertificate() *x509.Certificate
 	GetCertificateRequest() *x509.CertificateRequest
+	GetSshCertificate() *ssh.Certificate
 	// setters
-	SetPrivateKey(*rsa.PrivateKey)
+	SetPrivateKey(crypto.Signer)
 	SetCertificate(*x509.Certificate)
 	SetCertificateRequest(*x509.CertificateRequest)
+	SetSshCertificate(*ssh.Certificate)
 	// exporters
 	WritePrivateKey(io.Writer) error
 	WriteCertificate(io.Writer) error
 	WriteCertificateRequest(io.Writer) error
+	WriteSshCertificate(io.Writer) error
 	// calculations for compression archives
 	BlockPemLen() int64
 	BlockCsrLen() int64
@@ -29,10 +63,12 @@ type Record interface {
 	HasPrivateKey() bool
 	HasCertificate() bool
 	HasCertificateRequest() bool
+	HasSshCertificate() bool
 }
 
 type Storage interface {
-	// Persist will save the record to storage
+	// Persist will save the record to storage, the id of the
+	// record is set on the first persist and will not change
 	Persist(record Record) (*StorageKey, error)
 	// Open will search a record by given kid
 	// if not found will return nil
@@ -43,12 +79,17 @@ type Storage interface {
 	// Search will search for every record and check if the
 	// set CN will match. If no match nil will be returned.
 	Search(string) Record
+	// SearchSerial will return all records that have a
+	// certificate with the given serial number.
+	SearchSerial(*big.Int) []Record
 	// Remove a entry based on given kid
 	Remove(*StorageKey) error
 	// Has will check if record exist for given kid
 	Has(*StorageKey) bool
 	// GetCa will return list of keys that represent a ca
 	GetCa() []*StorageKey
+	// GetSshCa will return list of keys of the ssh ca keys
+	GetSshCa() []*StorageKey
 	// will return a new record based on storage type
 	NewRecord() Record
 	// Each will walk trough all records or til false is returned
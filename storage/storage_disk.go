@@ -1,17 +1,21 @@
 package storage
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/sha1"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
 	"sync"
 
 	"github.com/pbergman/caserver/util"
+	"golang.org/x/crypto/hkdf"
 )
 
 // DiskStorage is a Storage implementation that uses a
@@ -21,16 +25,22 @@ type DiskStorage struct {
 	key     *[32]byte
 	path    string
 	lock    sync.RWMutex
+	// the certificate serial numbers of the records
+	index serialIndex
 }
 
 func NewDiskStorage(path string, key *[32]byte) *DiskStorage {
 	// noop function so we don`t check errors
 	os.MkdirAll(path, 0700)
-	return &DiskStorage{
+	storage := &DiskStorage{
 		records: make([]*DiskRecord, 0),
 		key:     key,
 		path:    path,
 	}
+	// a failed migration will be retried on the next start and
+	// till then the plain text record can still be read.
+	storage.migrateAll()
+	return storage
 }
 
 func (d *DiskStorage) walkNames(call func(string) bool) error {
@@ -68,9 +78,21 @@ func (d *DiskStorage) Each(call func(Record) bool) error {
 }
 
 func (d *DiskStorage) Persist(r Record) (*StorageKey, error) {
+	var old *StorageKey
+	if id := r.GetId(); id != nil {
+		// copy because the id of the record will be replaced
+		old = NewStorageKeyFromBytes(id.Bytes())
+	}
 	d.lock.Lock()
-	defer d.lock.Unlock()
+	key, err := d.persist(r)
+	d.lock.Unlock()
+	if err == nil {
+		d.index.update(old, key, r.GetCertificate())
+	}
+	return key, err
+}
 
+func (d *DiskStorage) persist(r Record) (*StorageKey, error) {
 	if record, ok := r.(*DiskRecord); !ok {
 		return nil, fmt.Errorf("invalid record type, expected *DiskRecord got %T", r)
 	} else {
@@ -93,42 +115,46 @@ func (d *DiskStorage) Persist(r Record) (*StorageKey, error) {
 			}
 		}
 
-		var file *os.File
-		var err error
-
-		if record.id == nil {
-			file, err = ioutil.TempFile(d.path, "")
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			file, err = os.OpenFile(filepath.Join(d.path, record.id.String()), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-			if err != nil {
+		// the id is the hash of the content when the record is persisted
+		// for the first time and kept on updates (revoke, rollover etc.)
+		// so references to the record (see Record.GetPredecessor) and
+		// the locations given to clients will stay valid.
+		if record.id != nil {
+			if _, err := os.Stat(filepath.Join(d.path, record.id.String())); err != nil {
 				// should not happen, but file is removed
 				// so we will re persist and remove ref.
 				if os.IsNotExist(err) {
 					record.id = nil
-					return d.Persist(record)
+					return d.persist(record)
 				} else {
 					return nil, err
 				}
 			}
 		}
 
+		file, err := ioutil.TempFile(d.path, "")
+		if err != nil {
+			return nil, err
+		}
+
 		hasher := sha1.New()
 		writer := io.MultiWriter(file, hasher)
 
 		defer file.Close()
 
 		if raw, err := record.MarshalBinary(); err != nil {
+			os.Remove(file.Name())
 			return nil, err
 		} else {
 			if _, err := writer.Write(raw); err != nil {
+				os.Remove(file.Name())
 				return nil, err
 			}
 		}
 
-		record.id = NewStorageKeyFromBytes(hasher.Sum(nil))
+		if record.id == nil {
+			record.id = NewStorageKeyFromBytes(hasher.Sum(nil))
+		}
 		return record.id, os.Rename(file.Name(), filepath.Join(d.path, record.id.String()))
 	}
 }
@@ -146,6 +172,12 @@ func (d *DiskStorage) Has(key *StorageKey) bool {
 func (d *DiskStorage) Open(key *StorageKey) (Record, error) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
+	return d.open(key)
+}
+
+// open will read the record of the given key, the (read) lock
+// should be held by the caller.
+func (d *DiskStorage) open(key *StorageKey) (*DiskRecord, error) {
 	file, err := os.Open(filepath.Join(d.path, key.String()))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -154,28 +186,93 @@ func (d *DiskStorage) Open(key *StorageKey) (Record, error) {
 			return nil, err
 		}
 	}
-	if file != nil {
-		defer file.Close()
-		raw, buf := make([]byte, 0), make([]byte, 1024)
-		for {
-			n, err := file.Read(buf)
-			raw = append(raw, buf[:n]...)
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					return nil, err
-				}
+	defer file.Close()
+	raw, buf := make([]byte, 0), make([]byte, 1024)
+	for {
+		n, err := file.Read(buf)
+		raw = append(raw, buf[:n]...)
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return nil, err
 			}
 		}
-		record := NewDiskRecord(d, key)
-		if err := record.UnmarshalBinary(raw); err != nil {
-			return nil, err
-		} else {
-			return record, nil
+	}
+	record := NewDiskRecord(d, key)
+	if err := record.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// migrateAll will encrypt the records that were written before the encryption
+// was added, this is done once when the storage is created with the write lock
+// so no record is read or persisted at the same time.
+func (d *DiskStorage) migrateAll() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.walkNames(func(name string) bool {
+		key := NewStorageKeyFromString(name)
+		if key == nil || d.hasMode(name, MODE_ENCRYPTED) {
+			return true
 		}
+		if record, err := d.open(key); err == nil && !record.isEncrypted() {
+			d.migrate(record)
+		}
+		return true
+	})
+}
+
+// hasMode checks if the mode byte (after the signature) of the record has the given bits set
+func (d *DiskStorage) hasMode(name string, mode uint8) bool {
+	file, err := os.Open(filepath.Join(d.path, name))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, sha256.Size); err != nil {
+		return false
+	}
+	return mode == (mode & b[0])
+}
+
+// migrate will rewrite a record that was written before the encryption was
+// added, the file is replaced under the same name so the id of the record
+// will not change.
+func (d *DiskStorage) migrate(record *DiskRecord) error {
+	raw, err := record.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(d.path, ".migrate")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(raw); err != nil {
+		file.Close()
+		return err
 	}
-	return nil, nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(d.path, record.id.String()))
+}
+
+// newAEAD returns the cipher for encrypting the records, the key is derived
+// from the storage key so the key used for the HMAC is not reused.
+func (d *DiskStorage) newAEAD() (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, d.key[:], nil, []byte("caserver record encryption")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 func (d *DiskStorage) Lookup(id string) (Record, error) {
@@ -196,7 +293,7 @@ func (d *DiskStorage) Lookup(id string) (Record, error) {
 	if ret == "" {
 		return nil, nil
 	} else {
-		return d.Open(NewStorageKeyFromString(ret))
+		return d.open(NewStorageKeyFromString(ret))
 	}
 }
 
@@ -204,7 +301,7 @@ func (d *DiskStorage) Search(cn string) (found Record) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 	d.walkNames(func(kid string) bool {
-		if record, _ := d.Open(NewStorageKeyFromString(kid)); record != nil {
+		if record, _ := d.open(NewStorageKeyFromString(kid)); record != nil {
 			if pem := record.GetCertificate(); pem != nil {
 				if pem.Subject.CommonName == cn {
 					found = record
@@ -223,16 +320,43 @@ func (d *DiskStorage) Search(cn string) (found Record) {
 	return
 }
 
+// SearchSerial will return the records with a certificate with the given serial
+// number, records of different issuers can have the same serial number.
+func (d *DiskStorage) SearchSerial(serial *big.Int) []Record {
+	list := make([]Record, 0)
+	for _, key := range d.index.search(d, serial) {
+		if record, err := d.Open(key); err == nil && record != nil {
+			list = append(list, record)
+		}
+	}
+	return list
+}
+
 func (d *DiskStorage) Remove(key *StorageKey) error {
 	d.lock.Lock()
-	defer d.lock.Unlock()
-	return os.Remove(filepath.Join(d.path, key.String()))
+	err := os.Remove(filepath.Join(d.path, key.String()))
+	d.lock.Unlock()
+	if err == nil {
+		d.index.update(key, nil, nil)
+	}
+	return err
+}
+
+func (d *DiskStorage) GetCa() []*StorageKey {
+	return d.findMode(MODE_IS_CA)
+}
+
+func (d *DiskStorage) GetSshCa() []*StorageKey {
+	return d.findMode(MODE_IS_SSH_CA)
 }
 
-func (d *DiskStorage) GetCa() (list []*StorageKey) {
+// findMode returns the keys of the records that have the given mode bit set,
+// this only reads the mode byte (after the signature) of the record files.
+func (d *DiskStorage) findMode(mode uint8) (list []*StorageKey) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 	wg := new(sync.WaitGroup)
+	mutex := new(sync.Mutex)
 	d.walkNames(func(kid string) bool {
 		if file, err := os.Open(filepath.Join(d.path, kid)); err == nil {
 			wg.Add(1)
@@ -240,9 +364,11 @@ func (d *DiskStorage) GetCa() (list []*StorageKey) {
 				b := make([]byte, 1)
 				defer f.Close()
 				defer wg.Done()
-				if _, err := file.ReadAt(b, sha256.Size); err == nil {
-					if MODE_IS_CA == (MODE_IS_CA & b[0]) {
-						list = append(list, NewStorageKeyFromString(filepath.Base(file.Name())))
+				if _, err := f.ReadAt(b, sha256.Size); err == nil {
+					if mode == (mode & b[0]) {
+						mutex.Lock()
+						list = append(list, NewStorageKeyFromString(filepath.Base(f.Name())))
+						mutex.Unlock()
 					}
 				}
 			}(file)
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"crypto/x509"
+	"math/big"
+	"sync"
+)
+
+// serialIndex is an in memory index of the certificate serial numbers
+// of the records, it is build on first use and updated on persist.
+type serialIndex struct {
+	serials map[string][]StorageKey
+	keys    map[StorageKey]string
+	lock    sync.Mutex
+}
+
+// init will build the index from the records in the storage
+func (s *serialIndex) init(d *DiskStorage) {
+	if s.keys != nil {
+		return
+	}
+	s.serials = make(map[string][]StorageKey)
+	s.keys = make(map[StorageKey]string)
+	d.walkNames(func(name string) bool {
+		if key := NewStorageKeyFromString(name); key != nil {
+			if record, err := d.Open(key); err == nil && record != nil {
+				s.add(*key, record.GetCertificate())
+			}
+		}
+		return true
+	})
+}
+
+// add will index the serial of the certificate, a key that is already indexed
+// is replaced because the index could be build (see init) between persisting a
+// record and updating the index.
+func (s *serialIndex) add(key StorageKey, cert *x509.Certificate) {
+	if cert == nil {
+		return
+	}
+	s.remove(key)
+	serial := cert.SerialNumber.Text(16)
+	s.serials[serial] = append(s.serials[serial], key)
+	s.keys[key] = serial
+}
+
+func (s *serialIndex) remove(key StorageKey) {
+	serial, ok := s.keys[key]
+	if !ok {
+		return
+	}
+	delete(s.keys, key)
+	list := s.serials[serial][:0]
+	for _, k := range s.serials[serial] {
+		if k != key {
+			list = append(list, k)
+		}
+	}
+	if len(list) == 0 {
+		delete(s.serials, serial)
+	} else {
+		s.serials[serial] = list
+	}
+}
+
+// update will replace the old key with the given key, the index is only
+// updated when build because it will be complete when build later on.
+func (s *serialIndex) update(old, key *StorageKey, cert *x509.Certificate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.keys == nil {
+		return
+	}
+	if old != nil {
+		s.remove(*old)
+	}
+	if key != nil {
+		s.add(*key, cert)
+	}
+}
+
+func (s *serialIndex) search(d *DiskStorage, serial *big.Int) []*StorageKey {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init(d)
+	list := make([]*StorageKey, 0)
+	for _, key := range s.serials[serial.Text(16)] {
+		list = append(list, NewStorageKeyFromBytes(key.Bytes()))
+	}
+	return list
+}
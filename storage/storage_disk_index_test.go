@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSerialIndex_Update(t *testing.T) {
+	storage := newTestStorage(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: "example"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(raw)
+	record := storage.NewRecord()
+	record.SetCertificate(cert)
+
+	// persist without updating the index, like a search building
+	// the index before the persist could update the index
+	storage.lock.Lock()
+	id, err := storage.persist(record)
+	storage.lock.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list := storage.SearchSerial(cert.SerialNumber); len(list) != 1 {
+		t.Fatalf("expected 1 record got %d", len(list))
+	}
+
+	storage.index.update(nil, id, cert)
+
+	if list := storage.SearchSerial(cert.SerialNumber); len(list) != 1 {
+		t.Fatalf("expected the record to be indexed once got %d", len(list))
+	}
+
+	if err := storage.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if list := storage.SearchSerial(cert.SerialNumber); len(list) != 0 {
+		t.Fatalf("expected no records after remove got %d", len(list))
+	}
+}
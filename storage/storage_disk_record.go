@@ -2,20 +2,25 @@ package storage
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/hmac"
-	"crypto/rsa"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"io"
 
 	"github.com/pbergman/caserver/util"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
-	BLOCK_TYPE_KEY string = "RSA PRIVATE KEY"
-	BLOCK_TYPE_CER string = "CERTIFICATE"
-	BLOCK_TYPE_CSR string = "CERTIFICATE REQUEST"
+	BLOCK_TYPE_KEY       string = "RSA PRIVATE KEY"
+	BLOCK_TYPE_EC_KEY    string = "EC PRIVATE KEY"
+	BLOCK_TYPE_PKCS8_KEY string = "PRIVATE KEY"
+	BLOCK_TYPE_CER       string = "CERTIFICATE"
+	BLOCK_TYPE_CSR       string = "CERTIFICATE REQUEST"
+	BLOCK_TYPE_CRL       string = "X509 CRL"
 )
 
 func NewDiskRecord(s *DiskStorage, k *StorageKey) *DiskRecord {
@@ -34,8 +39,10 @@ type DiskRecord struct {
 	DiskRecordHeader
 }
 
-// MarshalBinary will convert the struct to a custom binary stream and every record
-// will be signed so that the UnmarshalBinary function can validate the data.
+// MarshalBinary will convert the struct to a custom binary stream, the data
+// sections are encrypted (see DiskStorage.newAEAD) and every record will be
+// signed over the header and ciphertext so that UnmarshalBinary can validate
+// the data before decrypting.
 func (d DiskRecord) MarshalBinary() (data []byte, err error) {
 
 	if d.storage == nil {
@@ -43,37 +50,80 @@ func (d DiskRecord) MarshalBinary() (data []byte, err error) {
 	}
 
 	buf := new(bytes.Buffer)
-	mac := hmac.New(sha256.New, d.storage.key[:])
-	writer := io.MultiWriter(mac, buf)
 
-	_, err = writer.Write([]byte{
-		byte(d.mode),
+	meta, err := d.meta.marshal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	head := []byte{
+		byte(d.mode&^(MODE_HAS_META|MODE_HAS_SSH)) | MODE_ENCRYPTED,
 		byte(d.size_key),
 		byte(d.size_key >> 8),
 		byte(d.size_pem),
 		byte(d.size_pem >> 8),
 		byte(d.size_csr),
 		byte(d.size_csr >> 8),
-	})
+	}
 
-	if err != nil {
-		return nil, err
+	// the meta size is only added when available so
+	// records without meta data keep the old format.
+	if size := len(meta); size > 0 {
+		head[0] |= MODE_HAS_META
+		head = append(head, byte(size), byte(size>>8))
+	}
+
+	if d.size_ssh > 0 {
+		head[0] |= MODE_HAS_SSH
+		head = append(head, byte(d.size_ssh), byte(d.size_ssh>>8))
 	}
 
 	if d.size_key > 0 {
-		if _, err := writer.Write(x509.MarshalPKCS1PrivateKey(d.key)); err != nil {
+		raw, _, err := marshalPrivateKey(d.key)
+		if err != nil {
 			return nil, err
 		}
+		buf.Write(raw)
 	}
 
 	if d.size_pem > 0 {
-		if _, err := writer.Write(d.pem.Raw); err != nil {
-			return nil, err
-		}
+		buf.Write(d.pem.Raw)
 	}
 
 	if d.size_csr > 0 {
-		if _, err := writer.Write(d.csr.Raw); err != nil {
+		buf.Write(d.csr.Raw)
+	}
+
+	if len(meta) > 0 {
+		buf.Write(meta)
+	}
+
+	if d.size_ssh > 0 {
+		buf.Write(d.ssh.Marshal())
+	}
+
+	aead, err := d.storage.newAEAD()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// the header is not encrypted (the mode is read without opening
+	// the record, see GetCa) but is authenticated as additional data.
+	sealed := aead.Seal(nil, nonce, buf.Bytes(), head)
+	mac := hmac.New(sha256.New, d.storage.key[:])
+	writer := io.MultiWriter(mac, buf)
+	buf.Reset()
+
+	for _, part := range [][]byte{head, nonce, sealed} {
+		if _, err := writer.Write(part); err != nil {
 			return nil, err
 		}
 	}
@@ -82,9 +132,14 @@ func (d DiskRecord) MarshalBinary() (data []byte, err error) {
 }
 
 // UnmarshalBinary a custom implementation for the gob.Decoder, it will
-// also validate the signature and return a error if that fails.
+// validate the signature and return a error if that fails. Records that
+// were written before the encryption are read as plain text.
 func (d *DiskRecord) UnmarshalBinary(data []byte) error {
 
+	if len(data) < sha256.Size+7 {
+		return errors.New("invalid record")
+	}
+
 	sig, data := data[:sha256.Size], data[sha256.Size:]
 	mac := hmac.New(sha256.New, d.storage.key[:])
 	mac.Write(data)
@@ -94,7 +149,7 @@ func (d *DiskRecord) UnmarshalBinary(data []byte) error {
 		return errors.New("invalid record")
 	}
 
-	head, data := data[:7], data[7:]
+	head, size := data[:7], 7
 
 	d.mode = head[0]
 	d.size_key = int(head[1]) | int(head[2])<<8
@@ -103,10 +158,38 @@ func (d *DiskRecord) UnmarshalBinary(data []byte) error {
 
 	var raw []byte
 	var err error
+	var size_meta int
+
+	if d.hasMeta() {
+		size_meta, size = int(data[size])|int(data[size+1])<<8, size+2
+	}
+
+	if d.hasSsh() {
+		d.size_ssh, size = int(data[size])|int(data[size+1])<<8, size+2
+	}
+
+	head, data = data[:size], data[size:]
+
+	if d.isEncrypted() {
+		aead, err := d.storage.newAEAD()
+		if err != nil {
+			return err
+		}
+		if len(data) < aead.NonceSize() {
+			return errors.New("invalid record")
+		}
+		if data, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], head); err != nil {
+			return err
+		}
+	}
+
+	if len(data) < d.size_key+d.size_pem+d.size_csr+size_meta+d.size_ssh {
+		return errors.New("invalid record")
+	}
 
 	if d.size_key > 0 {
 		raw, data = data[:d.size_key], data[d.size_key:]
-		if d.key, err = x509.ParsePKCS1PrivateKey(raw); err != nil {
+		if d.key, err = parsePrivateKey(raw); err != nil {
 			return err
 		}
 	}
@@ -125,13 +208,33 @@ func (d *DiskRecord) UnmarshalBinary(data []byte) error {
 		}
 	}
 
+	if size_meta > 0 {
+		raw, data = data[:size_meta], data[size_meta:]
+		if err := d.meta.unmarshal(raw); err != nil {
+			return err
+		}
+	}
+
+	if d.size_ssh > 0 {
+		raw, data = data[:d.size_ssh], data[d.size_ssh:]
+		key, err := ssh.ParsePublicKey(raw)
+		if err != nil {
+			return err
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return errors.New("invalid ssh certificate")
+		}
+		d.ssh = cert
+	}
+
 	return nil
 }
 
-func (d *DiskRecord) SetPrivateKey(key *rsa.PrivateKey) {
-	if key != nil {
+func (d *DiskRecord) SetPrivateKey(key crypto.Signer) {
+	if raw, _, err := marshalPrivateKey(key); err == nil {
 		d.key = key
-		d.size_key = len(x509.MarshalPKCS1PrivateKey(key))
+		d.size_key = len(raw)
 	} else {
 		d.key = nil
 		d.size_key = 0
@@ -158,6 +261,16 @@ func (d *DiskRecord) SetCertificateRequest(cert *x509.CertificateRequest) {
 	}
 }
 
+func (d *DiskRecord) SetSshCertificate(cert *ssh.Certificate) {
+	if cert != nil {
+		d.ssh = cert
+		d.size_ssh = len(cert.Marshal())
+	} else {
+		d.ssh = nil
+		d.size_ssh = 0
+	}
+}
+
 // BlockPemLen will calculate the size of a generated pem block.
 func (d DiskRecord) BlockPemLen() int64 {
 	return util.PemLength(d.size_pem, BLOCK_TYPE_CER)
@@ -168,13 +281,57 @@ func (d DiskRecord) BlockCsrLen() int64 {
 }
 
 func (d DiskRecord) BlockKeyLen() int64 {
-	return util.PemLength(d.size_key, BLOCK_TYPE_KEY)
+	if d.key == nil {
+		return 0
+	}
+	_, name, _ := marshalPrivateKey(d.key)
+	return util.PemLength(d.size_key, name)
 }
 
 func (d DiskRecord) IsCa() bool {
 	return d.isCa()
 }
 
+func (d *DiskRecord) SetRevocation(revocation *Revocation) {
+	d.meta.Revocation = revocation
+}
+
+func (d *DiskRecord) SetPredecessor(key *StorageKey) {
+	if key != nil {
+		d.meta.Predecessor = key.String()
+	} else {
+		d.meta.Predecessor = ""
+	}
+}
+
+func (d *DiskRecord) SetProfile(name string) {
+	d.meta.Profile = name
+}
+
+func (d DiskRecord) IsSshCa() bool {
+	return d.isSshCa()
+}
+
+func (d *DiskRecord) SetSshCa(ca bool) {
+	if ca {
+		d.mode |= MODE_IS_SSH_CA
+	} else {
+		d.mode &^= MODE_IS_SSH_CA
+	}
+}
+
+func (d DiskRecord) IsRetiring() bool {
+	return d.isRetiring()
+}
+
+func (d *DiskRecord) SetRetiring(retiring bool) {
+	if retiring {
+		d.mode |= MODE_IS_RETIRING
+	} else {
+		d.mode &^= MODE_IS_RETIRING
+	}
+}
+
 func (d DiskRecord) GetId() *StorageKey {
 	return d.id
 }
@@ -1,21 +1,26 @@
 package storage
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"io"
+
+	"golang.org/x/crypto/ssh"
 )
 
 // DiskRecordHeader is the data part of the record (DiskRecord)
 type DiskRecordData struct {
-	key *rsa.PrivateKey
+	key crypto.Signer
 	pem *x509.Certificate
 	csr *x509.CertificateRequest
+	ssh *ssh.Certificate
+	// the extra information of a record
+	meta DiskRecordMeta
 }
 
-func (d DiskRecordData) GetPrivateKey() *rsa.PrivateKey {
+func (d DiskRecordData) GetPrivateKey() crypto.Signer {
 	return d.key
 }
 
@@ -27,6 +32,29 @@ func (d DiskRecordData) GetCertificateRequest() *x509.CertificateRequest {
 	return d.csr
 }
 
+func (d DiskRecordData) GetSshCertificate() *ssh.Certificate {
+	return d.ssh
+}
+
+func (d DiskRecordData) GetRevocation() *Revocation {
+	return d.meta.Revocation
+}
+
+func (d DiskRecordData) IsRevoked() bool {
+	return nil != d.meta.Revocation
+}
+
+func (d DiskRecordData) GetPredecessor() *StorageKey {
+	if d.meta.Predecessor == "" {
+		return nil
+	}
+	return NewStorageKeyFromString(d.meta.Predecessor)
+}
+
+func (d DiskRecordData) GetProfile() string {
+	return d.meta.Profile
+}
+
 func (d DiskRecordData) HasPrivateKey() bool {
 	return nil != d.key
 }
@@ -39,6 +67,10 @@ func (d DiskRecordData) HasCertificateRequest() bool {
 	return nil != d.csr
 }
 
+func (d DiskRecordData) HasSshCertificate() bool {
+	return nil != d.ssh
+}
+
 func (d DiskRecordData) WritePrivateKey(w io.Writer) error {
 	return d.export(d.key, w)
 }
@@ -51,13 +83,26 @@ func (d DiskRecordData) WriteCertificateRequest(w io.Writer) error {
 	return d.export(d.csr, w)
 }
 
+// WriteSshCertificate will write the certificate in the authorized keys format
+func (d DiskRecordData) WriteSshCertificate(w io.Writer) error {
+	if d.ssh == nil {
+		return fmt.Errorf("could not export a nil value")
+	}
+	_, err := w.Write(ssh.MarshalAuthorizedKey(d.ssh))
+	return err
+}
+
 func (d DiskRecordData) export(v interface{}, w io.Writer) error {
 	var block *pem.Block
 	switch t := v.(type) {
-	case *rsa.PrivateKey:
+	case crypto.Signer:
+		raw, name, err := marshalPrivateKey(t)
+		if err != nil {
+			return err
+		}
 		block = &pem.Block{
-			Type:  BLOCK_TYPE_KEY,
-			Bytes: x509.MarshalPKCS1PrivateKey(t),
+			Type:  name,
+			Bytes: raw,
 		}
 	case *x509.Certificate:
 		block = &pem.Block{
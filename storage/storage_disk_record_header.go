@@ -2,6 +2,11 @@ package storage
 
 const (
 	MODE_IS_CA uint8 = (1 << iota)
+	MODE_IS_RETIRING
+	MODE_HAS_META
+	MODE_HAS_SSH
+	MODE_IS_SSH_CA
+	MODE_ENCRYPTED
 )
 
 // DiskRecordHeader is the header part of the record (DiskRecord)
@@ -16,15 +21,36 @@ type DiskRecordHeader struct {
 	size_key int
 	size_pem int
 	size_csr int
+	size_ssh int
 	// a ref back to the storage manager used to get the key
 	// for signing and verifying the signature.
 	storage *DiskStorage
 	// when file opened, the key will be added so when updating
-	// it will know the old location. This because the key is
-	// based on the content.
+	// it will know the location. The key is based on the content
+	// when the record is persisted for the first time.
 	id *StorageKey
 }
 
 func (h DiskRecordHeader) isCa() bool {
 	return MODE_IS_CA == (MODE_IS_CA & h.mode)
 }
+
+func (h DiskRecordHeader) hasMeta() bool {
+	return MODE_HAS_META == (MODE_HAS_META & h.mode)
+}
+
+func (h DiskRecordHeader) isRetiring() bool {
+	return MODE_IS_RETIRING == (MODE_IS_RETIRING & h.mode)
+}
+
+func (h DiskRecordHeader) hasSsh() bool {
+	return MODE_HAS_SSH == (MODE_HAS_SSH & h.mode)
+}
+
+func (h DiskRecordHeader) isSshCa() bool {
+	return MODE_IS_SSH_CA == (MODE_IS_SSH_CA & h.mode)
+}
+
+func (h DiskRecordHeader) isEncrypted() bool {
+	return MODE_ENCRYPTED == (MODE_ENCRYPTED & h.mode)
+}
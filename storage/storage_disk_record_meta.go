@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// DiskRecordMeta is the (optional) meta data part of the record (DiskRecord)
+// and holds the information that is not part of the key or certificates.
+type DiskRecordMeta struct {
+	Revocation *Revocation `json:"revocation,omitempty"`
+	// the id of the record this record is a renewal of
+	Predecessor string `json:"predecessor,omitempty"`
+	// the name of the profile used for issuing the certificate
+	Profile string `json:"profile,omitempty"`
+}
+
+// marshal will return nil when no meta data is set
+func (m DiskRecordMeta) marshal() ([]byte, error) {
+	raw, err := json.Marshal(m)
+	if err != nil || string(raw) == "{}" {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func (m *DiskRecordMeta) unmarshal(raw []byte) error {
+	return json.Unmarshal(raw, m)
+}
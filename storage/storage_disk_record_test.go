@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func newTestStorage(t *testing.T) *DiskStorage {
+	key := new([32]byte)
+	rand.Read(key[:])
+	return NewDiskStorage(t.TempDir(), key)
+}
+
+func TestDiskRecord_Encrypted(t *testing.T) {
+	storage := newTestStorage(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw, _ := x509.MarshalECPrivateKey(key)
+	record := storage.NewRecord()
+	record.SetPrivateKey(key)
+
+	id, err := storage.Persist(record)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(storage.path, id.String())
+	data, _ := ioutil.ReadFile(file)
+
+	if bytes.Contains(data, raw) {
+		t.Fatal("expected the private key to be encrypted")
+	}
+
+	opened, err := storage.Open(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.Equal(opened.GetPrivateKey()) {
+		t.Fatal("expected the decrypted private key")
+	}
+
+	// the hmac covers the ciphertext so a modified record should be refused
+	data[len(data)-1] ^= 1
+	ioutil.WriteFile(file, data, 0600)
+
+	if _, err := storage.Open(id); err == nil {
+		t.Fatal("expected an error for a modified record")
+	}
+}
+
+func TestDiskRecord_Migrate(t *testing.T) {
+	storage := newTestStorage(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw, _ := x509.MarshalECPrivateKey(key)
+
+	// a record with only a private key in the format before the encryption
+	body := append([]byte{0, byte(len(raw)), byte(len(raw) >> 8), 0, 0, 0, 0}, raw...)
+	mac := hmac.New(sha256.New, storage.key[:])
+	mac.Write(body)
+	id := NewStorageKeyFromString("0123456789abcdef0123456789abcdef01234567")
+	file := filepath.Join(storage.path, id.String())
+
+	if err := ioutil.WriteFile(file, append(mac.Sum(nil), body...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := storage.Open(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.Equal(record.GetPrivateKey()) {
+		t.Fatal("expected the private key of the plain text record")
+	}
+
+	storage = NewDiskStorage(storage.path, storage.key)
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		t.Fatal("expected the migrated record to keep its id")
+	}
+
+	if bytes.Contains(data, raw) || data[sha256.Size]&MODE_ENCRYPTED == 0 {
+		t.Fatal("expected the record to be encrypted on start")
+	}
+
+	if migrated, err := storage.Open(id); err != nil || !key.Equal(migrated.GetPrivateKey()) {
+		t.Fatalf("expected the migrated record to be readable: %v", err)
+	}
+
+	if list, _ := ioutil.ReadDir(storage.path); len(list) != 1 {
+		t.Fatalf("expected only the record in the storage, got %d files", len(list))
+	}
+
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// marshalPrivateKey will encode the key in the (most common) format for its
+// type and return the raw data together with the name of the pem block. So
+// rsa keys will be PKCS #1, ecdsa keys SEC 1 and all others PKCS #8.
+func marshalPrivateKey(key crypto.Signer) ([]byte, string, error) {
+	switch t := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(t), BLOCK_TYPE_KEY, nil
+	case *ecdsa.PrivateKey:
+		raw, err := x509.MarshalECPrivateKey(t)
+		return raw, BLOCK_TYPE_EC_KEY, err
+	case nil:
+		return nil, "", errors.New("could not marshal a nil private key")
+	default:
+		raw, err := x509.MarshalPKCS8PrivateKey(t)
+		return raw, BLOCK_TYPE_PKCS8_KEY, err
+	}
+}
+
+// parsePrivateKey is the counterpart of marshalPrivateKey and will try
+// the PKCS #1, SEC 1 and PKCS #8 encoding until one succeeds.
+func parsePrivateKey(raw []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(raw); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(raw); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // SetDefaults will read the default tag and set it to struct field. It is also possible
@@ -56,6 +57,22 @@ func setValue(v reflect.Value, d string) {
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(d)
+	case reflect.Bool:
+		if val, err := strconv.ParseBool(d); err == nil {
+			v.SetBool(val)
+		}
+	case reflect.Int:
+		if val, err := strconv.Atoi(d); err == nil {
+			v.SetInt(int64(val))
+		}
+	case reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			if val, err := time.ParseDuration(d); err == nil {
+				v.SetInt(int64(val))
+			}
+		} else if val, err := strconv.ParseInt(d, 10, 64); err == nil {
+			v.SetInt(val)
+		}
 	case reflect.Array:
 		switch v.Type().Elem().Kind() {
 		case reflect.Int:
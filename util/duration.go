@@ -0,0 +1,28 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration works the same as time.ParseDuration but will also support
+// a leading day unit because durations in days are more common for
+// certificates, so for example `30d` or `1d12h`.
+func ParseDuration(s string) (time.Duration, error) {
+	if i := strings.Index(s, "d"); i > 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		var rest time.Duration
+		if s = s[i+1:]; s != "" {
+			if rest, err = time.ParseDuration(s); err != nil {
+				return 0, err
+			}
+		}
+		return time.Duration(days)*24*time.Hour + rest, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+
+	list := map[string]time.Duration{
+		"30d":   30 * 24 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"90m":   90 * time.Minute,
+		"397d":  397 * 24 * time.Hour,
+	}
+
+	for v, k := range list {
+		if ret, err := ParseDuration(v); err != nil || ret != k {
+			t.Fatalf("expected '%s' got '%s' (%v)", k, ret, err)
+		}
+	}
+
+	for _, v := range []string{"d", "xd", "1dx", "1y"} {
+		if _, err := ParseDuration(v); err == nil {
+			t.Fatalf("expected an error for '%s'", v)
+		}
+	}
+}
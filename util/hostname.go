@@ -1,23 +1,23 @@
 package util
 
-import "regexp"
-
-var (
-	// Domain names may be formed from the set of alphanumeric ASCII characters (a-z, A-Z, 0-9),
-	// but characters are case-insensitive. In addition the hyphen is permitted if it is
-	// surrounded by characters, digits or hyphens, although it is not to start or end a label.
-	wildcard string = `(?:[a-z|0-9]+[a-z|0-9|\-]+[a-z|0-9]+\.)?`
-)
+import "strings"
 
+// ValidHost checks if the host is covered by the subject (a dns name of a certificate),
+// the names are case-insensitive and a wildcard subject like *.example.com will only
+// match a single label so it matches foo.example.com but not example.com or
+// foo.bar.example.com (rfc6125 section 6.4.3).
 func ValidHost(host, subject string) bool {
-	var reg *regexp.Regexp
-
-	if subject[0] == '*' && subject[1] == '.' {
-		reg = regexp.MustCompile(`(?i)^` + wildcard + regexp.QuoteMeta(subject[2:]) + `?`)
-	} else {
-		// case-insensitive
-		reg = regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(subject) + `$`)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	subject = strings.ToLower(strings.TrimSuffix(subject, "."))
+	if strings.HasPrefix(subject, "*.") {
+		index := strings.IndexByte(host, '.')
+		return index > 0 && host[index+1:] == subject[2:]
 	}
+	return host == subject
+}
 
-	return reg.MatchString(host)
+// OverlapHost checks if two dns names (of certificates) can match the same host,
+// which is the case when they are the same or one of them is covered by the other.
+func OverlapHost(a, b string) bool {
+	return ValidHost(a, b) || ValidHost(b, a)
 }
@@ -6,8 +6,14 @@ func TestHostname_wildcard(t *testing.T) {
 	if !ValidHost("foo.example.com", "*.example.com") {
 		t.Fatal("Expected '*.example.com' to match 'foo.example.com'")
 	}
-	if !ValidHost("example.com", "*.example.com") {
-		t.Fatal("Expected '*.example.com' to match 'example.com'")
+	if ValidHost("example.com", "*.example.com") {
+		t.Fatal("Not expected '*.example.com' to match 'example.com'")
+	}
+	if ValidHost("foo.bar.example.com", "*.example.com") {
+		t.Fatal("Not expected '*.example.com' to match 'foo.bar.example.com'")
+	}
+	if ValidHost("foo.example.com.evil", "*.example.com") {
+		t.Fatal("Not expected '*.example.com' to match 'foo.example.com.evil'")
 	}
 }
 
@@ -19,4 +25,27 @@ func TestHostname_name(t *testing.T) {
 	if !ValidHost("example.com", "example.com") {
 		t.Fatal("Expected 'example.com' to match 'example.com'")
 	}
+
+	if !ValidHost("Example.COM.", "example.com") {
+		t.Fatal("Expected 'example.com' to match 'Example.COM.'")
+	}
+}
+
+func TestHostname_overlap(t *testing.T) {
+	for _, c := range []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"*.example.com", "api.example.com", true},
+		{"api.example.com", "*.example.com", true},
+		{"*.example.com", "*.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "*.api.example.com", false},
+		{"api.example.com", "web.example.com", false},
+	} {
+		if OverlapHost(c.a, c.b) != c.overlap {
+			t.Fatalf("expected overlap of '%s' and '%s' to be %t", c.a, c.b, c.overlap)
+		}
+	}
 }
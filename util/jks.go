@@ -0,0 +1,147 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic             = 0xfeedfeed
+	jksVersion           = 2
+	jksTagPrivateKey     = 1
+	jksTagTrustedCert    = 2
+	jksCertificateType   = "X.509"
+	jksIntegrityWhitener = "Mighty Aphrodite"
+)
+
+// the (proprietary) sun.security.provider.KeyProtector algorithm
+var oidJksKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// rfc5208 section 6
+type jksEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// JksEntry is an entry of a java keystore, with a key it will be stored as a
+// private key entry with the certificates as chain and else the first
+// certificate will be stored as a trusted certificate entry.
+type JksEntry struct {
+	Alias string
+	Key   crypto.PrivateKey
+	Certs []*x509.Certificate
+}
+
+// MarshalJKS will create a java keystore (JKS) of the given entries, the
+// private keys and the integrity of the store are protected with password.
+func MarshalJKS(password string, entries ...JksEntry) ([]byte, error) {
+	secret := jksPassword(password)
+	buf := new(bytes.Buffer)
+	date := time.Now().UnixNano() / int64(time.Millisecond)
+	seen := make(map[string]bool, len(entries))
+	writeUint32(buf, jksMagic)
+	writeUint32(buf, jksVersion)
+	writeUint32(buf, uint32(len(entries)))
+	for _, entry := range entries {
+		// aliases are case insensitive, keytool also stores them in lower case
+		alias := strings.ToLower(entry.Alias)
+		if alias == "" || seen[alias] {
+			return nil, errors.New("missing or duplicate alias '" + alias + "'")
+		}
+		seen[alias] = true
+		if len(entry.Certs) == 0 {
+			return nil, errors.New("missing certificate for alias '" + alias + "'")
+		}
+		if entry.Key != nil {
+			key, err := jksProtectKey(secret, entry.Key)
+			if err != nil {
+				return nil, err
+			}
+			writeUint32(buf, jksTagPrivateKey)
+			writeUTF(buf, alias)
+			binary.Write(buf, binary.BigEndian, date)
+			writeUint32(buf, uint32(len(key)))
+			buf.Write(key)
+			writeUint32(buf, uint32(len(entry.Certs)))
+			for _, cert := range entry.Certs {
+				writeJksCertificate(buf, cert)
+			}
+		} else {
+			writeUint32(buf, jksTagTrustedCert)
+			writeUTF(buf, alias)
+			binary.Write(buf, binary.BigEndian, date)
+			writeJksCertificate(buf, entry.Certs[0])
+		}
+	}
+	hash := sha1.New()
+	hash.Write(secret)
+	hash.Write([]byte(jksIntegrityWhitener))
+	hash.Write(buf.Bytes())
+	buf.Write(hash.Sum(nil))
+	return buf.Bytes(), nil
+}
+
+// jksPassword returns the password as (java) chars in big endian
+func jksPassword(password string) []byte {
+	chars := utf16.Encode([]rune(password))
+	buf := make([]byte, 2*len(chars))
+	for i, c := range chars {
+		binary.BigEndian.PutUint16(buf[2*i:], c)
+	}
+	return buf
+}
+
+// jksProtectKey will encrypt the PKCS #8 encoded key as done by the sun key
+// protector, the key is xored with a stream of chained sha1 digests of the
+// password and salt and appended with a sha1 digest of the password and key.
+func jksProtectKey(password []byte, key crypto.PrivateKey) ([]byte, error) {
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, sha1.Size, 2*sha1.Size+len(plain))
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	digest := data[:sha1.Size]
+	for i := 0; i < len(plain); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plain); j++ {
+			data = append(data, plain[i+j]^digest[j])
+		}
+	}
+	check := sha1.Sum(append(append([]byte{}, password...), plain...))
+	data = append(data, check[:]...)
+	return asn1.Marshal(jksEncryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJksKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: data,
+	})
+}
+
+func writeJksCertificate(buf *bytes.Buffer, cert *x509.Certificate) {
+	writeUTF(buf, jksCertificateType)
+	writeUint32(buf, uint32(len(cert.Raw)))
+	buf.Write(cert.Raw)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	binary.Write(buf, binary.BigEndian, v)
+}
+
+// writeUTF writes the string the same as java DataOutput.writeUTF, which
+// is the same as utf-8 for strings without null or supplementary characters.
+func writeUTF(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
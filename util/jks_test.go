@@ -0,0 +1,153 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func readJksUTF(t *testing.T, r io.Reader) string {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func readJksBytes(t *testing.T, r io.Reader) []byte {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestMarshalJKS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certs := make([]*x509.Certificate, 2)
+	for i := range certs {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 1)), Subject: pkix.Name{CommonName: "example"}, NotAfter: time.Now().Add(time.Hour)}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs[i], _ = x509.ParseCertificate(raw)
+	}
+
+	raw, err := MarshalJKS("changeit", JksEntry{Alias: "Server", Key: key, Certs: certs}, JksEntry{Alias: "ca", Certs: certs[1:]})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, sum := raw[:len(raw)-sha1.Size], raw[len(raw)-sha1.Size:]
+	password := jksPassword("changeit")
+
+	if exp := sha1.Sum(append(append(password, jksIntegrityWhitener...), data...)); !bytes.Equal(exp[:], sum) {
+		t.Fatal("invalid integrity digest")
+	}
+
+	reader := bytes.NewReader(data)
+	var head [3]uint32
+
+	if err := binary.Read(reader, binary.BigEndian, &head); err != nil || head != [3]uint32{jksMagic, jksVersion, 2} {
+		t.Fatalf("invalid header %x", head)
+	}
+
+	// private key entry
+	var tag uint32
+	var date int64
+	binary.Read(reader, binary.BigEndian, &tag)
+
+	if alias := readJksUTF(t, reader); tag != jksTagPrivateKey || alias != "server" {
+		t.Fatalf("expected private key entry 'server' got %d '%s'", tag, alias)
+	}
+
+	binary.Read(reader, binary.BigEndian, &date)
+
+	var info jksEncryptedPrivateKeyInfo
+
+	if _, err := asn1.Unmarshal(readJksBytes(t, reader), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidJksKeyProtector) {
+		t.Fatalf("unexpected algorithm %s", info.Algorithm.Algorithm)
+	}
+
+	salt, enc, check := info.EncryptedData[:sha1.Size], info.EncryptedData[sha1.Size:len(info.EncryptedData)-sha1.Size], info.EncryptedData[len(info.EncryptedData)-sha1.Size:]
+	plain := make([]byte, len(enc))
+	digest := salt
+
+	for i := range enc {
+		if i%sha1.Size == 0 {
+			sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+			digest = sum[:]
+		}
+		plain[i] = enc[i] ^ digest[i%sha1.Size]
+	}
+
+	if exp := sha1.Sum(append(append([]byte{}, password...), plain...)); !bytes.Equal(exp[:], check) {
+		t.Fatal("invalid key check digest")
+	}
+
+	if parsed, err := x509.ParsePKCS8PrivateKey(plain); err != nil || !key.Equal(parsed) {
+		t.Fatalf("expected the private key: %v", err)
+	}
+
+	var chain uint32
+	binary.Read(reader, binary.BigEndian, &chain)
+
+	if chain != 2 {
+		t.Fatalf("expected a chain of 2 got %d", chain)
+	}
+
+	for i := range certs {
+		if typ := readJksUTF(t, reader); typ != jksCertificateType {
+			t.Fatalf("unexpected certificate type %s", typ)
+		}
+		if !bytes.Equal(readJksBytes(t, reader), certs[i].Raw) {
+			t.Fatalf("expected certificate %d in chain", i)
+		}
+	}
+
+	// trusted certificate entry
+	binary.Read(reader, binary.BigEndian, &tag)
+
+	if alias := readJksUTF(t, reader); tag != jksTagTrustedCert || alias != "ca" {
+		t.Fatalf("expected trusted certificate entry 'ca' got %d '%s'", tag, alias)
+	}
+
+	binary.Read(reader, binary.BigEndian, &date)
+	readJksUTF(t, reader)
+
+	if !bytes.Equal(readJksBytes(t, reader), certs[1].Raw) {
+		t.Fatal("expected the trusted certificate")
+	}
+
+	if reader.Len() != 0 {
+		t.Fatalf("unexpected %d trailing bytes", reader.Len())
+	}
+
+	if _, err := MarshalJKS("changeit", JksEntry{Alias: "a", Certs: certs}, JksEntry{Alias: "A", Certs: certs}); err == nil {
+		t.Fatal("expected an error for a duplicate alias")
+	}
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestMarshalPKCS7(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certs := make([]*x509.Certificate, 2)
+	for i := range certs {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(int64(i + 1)), Subject: pkix.Name{CommonName: "example"}, NotAfter: time.Now().Add(time.Hour)}
+		raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs[i], _ = x509.ParseCertificate(raw)
+	}
+
+	raw, err := MarshalPKCS7(certs...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info pkcs7ContentInfo
+
+	if rest, err := asn1.Unmarshal(raw, &info); err != nil || len(rest) > 0 {
+		t.Fatalf("invalid content info: %v", err)
+	}
+
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		t.Fatalf("expected signed data got %s", info.ContentType)
+	}
+
+	var data pkcs7SignedData
+
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data.Certificates.Bytes, append(certs[0].Raw, certs[1].Raw...)) {
+		t.Fatal("expected the certificates in the signed data")
+	}
+
+	parsed, err := x509.ParseCertificates(data.Certificates.Bytes)
+
+	if err != nil || len(parsed) != 2 {
+		t.Fatalf("expected 2 certificates: %v", err)
+	}
+}